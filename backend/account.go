@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 邮箱验证与找回密码
+// ===========================
+
+// 一次性令牌用途
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = 30 * time.Minute
+	minPasswordLen   = 6
+)
+
+// UserToken 邮件中发送的一次性令牌，库中只保存哈希
+type UserToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	Purpose   string `gorm:"size:32"`
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	Email     string // 验证邮箱时记录目标地址，防止改邮箱后旧链接验证新地址
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+var errInvalidToken = errors.New("链接无效或已过期")
+
+// normalizeEmail 校验并规范化邮箱地址
+func normalizeEmail(raw string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(raw))
+	if err != nil || addr.Name != "" {
+		return "", errors.New("邮箱格式不正确")
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// createUserToken 生成一次性令牌，同一用途下之前未使用的令牌全部作废
+func createUserToken(user User, purpose, email string, ttl time.Duration) (string, error) {
+	token, hash := newRefreshToken()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&UserToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&UserToken{UserID: user.ID, Purpose: purpose, TokenHash: hash, Email: email, ExpiresAt: time.Now().Add(ttl)}).Error
+	})
+	return token, err
+}
+
+// consumeUserToken 校验并核销令牌，成功后在同一事务内执行 apply
+func consumeUserToken(token, purpose string, apply func(tx *gorm.DB, t UserToken) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var t UserToken
+		if err := tx.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&t).Error; err != nil {
+			return errInvalidToken
+		}
+		if t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+			return errInvalidToken
+		}
+		res := tx.Model(&UserToken{}).Where("id = ? AND used_at IS NULL", t.ID).Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errInvalidToken
+		}
+		return apply(tx, t)
+	})
+}
+
+func appLink(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", APP_BASE_URL, path, url.QueryEscape(token))
+}
+
+// sendVerificationEmail 向用户当前邮箱发送验证链接，发送失败只记录日志
+func sendVerificationEmail(user User) {
+	if user.Email == nil {
+		return
+	}
+	token, err := createUserToken(user, TokenVerifyEmail, *user.Email, verifyEmailTTL)
+	if err != nil {
+		log.Printf("⚠️ 生成邮箱验证令牌失败: %v", err)
+		return
+	}
+	body := fmt.Sprintf("%s，你好：\n\n请点击以下链接验证你的邮箱（24小时内有效）：\n%s\n\n如果这不是你本人的操作，请忽略本邮件。",
+		user.Username, appLink("/verify-email", token))
+	if err := mailer.Send(*user.Email, "【在线教育平台】验证你的邮箱", body); err != nil {
+		log.Printf("⚠️ 发送验证邮件失败: %v", err)
+	}
+}
+
+// VerifyEmailHandler 核销邮件中的验证令牌
+func VerifyEmailHandler(c *gin.Context) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	err := consumeUserToken(req.Token, TokenVerifyEmail, func(tx *gorm.DB, t UserToken) error {
+		res := tx.Model(&User{}).Where("id = ? AND email = ?", t.UserID, t.Email).
+			Update("email_verified_at", time.Now())
+		if res.Error == nil && res.RowsAffected == 0 {
+			return errInvalidToken
+		}
+		return res.Error
+	})
+	if errors.Is(err, errInvalidToken) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "验证失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "邮箱验证成功"})
+}
+
+// ResendVerificationHandler 重新发送验证邮件
+func ResendVerificationHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var user User
+	db.First(&user, userID)
+	if user.Email == nil {
+		c.JSON(400, gin.H{"error": "请先设置邮箱"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(400, gin.H{"error": "邮箱已验证"})
+		return
+	}
+	sendVerificationEmail(user)
+	c.JSON(200, gin.H{"message": "验证邮件已发送"})
+}
+
+// ForgotPasswordHandler 向已验证的邮箱发送重置链接；无论账号是否存在都返回相同结果，避免枚举邮箱
+func ForgotPasswordHandler(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	resp := gin.H{"message": "如果该邮箱已绑定并验证，你将收到一封重置密码邮件"}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		c.JSON(200, resp)
+		return
+	}
+	var user User
+	if db.Where("email = ? AND email_verified_at IS NOT NULL", email).First(&user).Error != nil {
+		c.JSON(200, resp)
+		return
+	}
+	token, err := createUserToken(user, TokenResetPassword, email, resetPasswordTTL)
+	if err != nil {
+		log.Printf("⚠️ 生成重置令牌失败: %v", err)
+		c.JSON(200, resp)
+		return
+	}
+	body := fmt.Sprintf("%s，你好：\n\n我们收到了重置密码的请求，请点击以下链接设置新密码（30分钟内有效）：\n%s\n\n如果这不是你本人的操作，请忽略本邮件，你的密码不会被修改。",
+		user.Username, appLink("/reset-password", token))
+	if err := mailer.Send(email, "【在线教育平台】重置密码", body); err != nil {
+		log.Printf("⚠️ 发送重置邮件失败: %v", err)
+	}
+	c.JSON(200, resp)
+}
+
+// ResetPasswordHandler 用邮件中的令牌设置新密码，并让该用户所有已登录设备和 API Key 失效
+func ResetPasswordHandler(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if len(req.Password) < minPasswordLen {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("密码至少 %d 位", minPasswordLen)})
+		return
+	}
+	hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	err := consumeUserToken(req.Token, TokenResetPassword, func(tx *gorm.DB, t UserToken) error {
+		if err := tx.Model(&User{}).Where("id = ?", t.UserID).Updates(map[string]interface{}{
+			"password":             string(hashedPwd),
+			"must_change_password": false,
+		}).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, t.UserID, 0)
+	})
+	if errors.Is(err, errInvalidToken) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "重置失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "密码已重置，请重新登录"})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 个人 API Key
+// ===========================
+
+// API Key 形如 edu_<8位前缀>_<密钥>，前缀明文保存用于在列表中辨认，完整 Key 只保存哈希
+const (
+	apiKeyMarker       = "edu_"
+	apiKeyDefaultDays  = 90
+	apiKeyMaxDays      = 365
+	apiKeyMaxPerUser   = 20
+	apiKeyTouchMinutes = 5
+)
+
+type APIKey struct {
+	gorm.Model
+	UserID     uint        `gorm:"index" json:"user_id"`
+	Name       string      `json:"name"`
+	Prefix     string      `gorm:"size:16;uniqueIndex" json:"prefix"`
+	KeyHash    string      `gorm:"size:64;uniqueIndex" json:"-"`
+	Scopes     JSONStrings `gorm:"type:text" json:"scopes"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	LastUsedAt *time.Time  `json:"last_used_at"`
+	LastUsedIP string      `json:"last_used_ip"`
+	RevokedAt  *time.Time  `json:"revoked_at"`
+}
+
+type apiScope struct {
+	Name  string
+	allow func(method, path string) bool
+}
+
+// apiKeyScopes 每个 scope 允许访问的接口，最终权限还要与用户角色的权限取交集
+var apiKeyScopes = map[string]apiScope{
+	"read": {"只读访问", func(method, path string) bool {
+		return method == http.MethodGet
+	}},
+	"courses:write": {"管理课程内容", func(method, path string) bool {
+		return method != http.MethodGet && (strings.HasPrefix(path, "/api/v1/courses") || path == "/api/v1/upload")
+	}},
+	"courses:audit": {"审核课程", func(method, path string) bool {
+		return path == "/api/v1/admin/audit"
+	}},
+	"gradebook:read": {"导出成绩册", func(method, path string) bool {
+		return method == http.MethodGet && path == "/api/v1/courses/:id/gradebook"
+	}},
+	"homework:grade": {"批改作业", func(method, path string) bool {
+		return path == "/api/v1/homework/grade" || (method == http.MethodGet && strings.HasPrefix(path, "/api/v1/homework"))
+	}},
+}
+
+// apiKeyForbidden 账号安全相关和考试作答接口只能在交互式登录下使用
+func apiKeyForbidden(path string) bool {
+	if path == "/api/v1/admin/audit" {
+		return false
+	}
+	for _, p := range []string{"/api/v1/user/", "/api/v1/admin/", "/api/v1/logout", "/api/v1/teacher-applications",
+		"/api/v1/quizzes/", "/api/v1/quiz-attempts/"} {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func apiKeyAllows(scopes []string, method, path string) bool {
+	if apiKeyForbidden(path) {
+		return false
+	}
+	for _, s := range scopes {
+		if scope, ok := apiKeyScopes[s]; ok && scope.allow(method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey AuthMiddleware 中处理 API Key 认证，失败时已中止请求
+func authenticateAPIKey(c *gin.Context, key string) bool {
+	var apiKey APIKey
+	now := time.Now()
+	if err := db.Where("key_hash = ?", hashToken(key)).First(&apiKey).Error; err != nil ||
+		apiKey.RevokedAt != nil || now.After(apiKey.ExpiresAt) {
+		c.AbortWithStatusJSON(401, gin.H{"error": "API Key 无效或已过期"})
+		return false
+	}
+	var user User
+	if err := db.Select("id", "role", "disabled_at", "must_change_password").First(&user, apiKey.UserID).Error; err != nil ||
+		user.disabled() || user.MustChangePassword {
+		c.AbortWithStatusJSON(401, gin.H{"error": "用户状态异常"})
+		return false
+	}
+	if !apiKeyAllows(apiKey.Scopes, c.Request.Method, c.FullPath()) {
+		c.AbortWithStatusJSON(403, gin.H{"error": "API Key 无权访问该接口"})
+		return false
+	}
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchMinutes*time.Minute {
+		db.Model(&apiKey).UpdateColumns(map[string]interface{}{"last_used_at": now, "last_used_ip": c.ClientIP()})
+	}
+	c.Set("userID", user.ID)
+	c.Set("sessionID", uint(0))
+	c.Set("apiKeyID", apiKey.ID)
+	c.Set("role", user.Role)
+	return true
+}
+
+// bearerAPIKey 从 X-API-Key 或 Authorization: Bearer 中取出 API Key
+func bearerAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && strings.HasPrefix(token, apiKeyMarker) {
+		return token
+	}
+	return ""
+}
+
+func ListAPIKeysHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var keys []APIKey
+	db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("id desc").Find(&keys)
+	scopes := make([]gin.H, 0, len(apiKeyScopes))
+	for _, code := range slices.Sorted(maps.Keys(apiKeyScopes)) {
+		scopes = append(scopes, gin.H{"code": code, "name": apiKeyScopes[code].Name})
+	}
+	c.JSON(200, gin.H{"data": keys, "scopes": scopes})
+}
+
+// CreateAPIKeyHandler 创建 API Key，完整 Key 只在创建时返回一次
+func CreateAPIKeyHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" || len(req.Scopes) == 0 {
+		c.JSON(400, gin.H{"error": "请填写名称并至少选择一个权限范围"})
+		return
+	}
+	for _, s := range req.Scopes {
+		if _, ok := apiKeyScopes[s]; !ok {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("未知的权限范围 %s", s)})
+			return
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = apiKeyDefaultDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > apiKeyMaxDays {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("有效期需在 1-%d 天之间", apiKeyMaxDays)})
+		return
+	}
+	var count int64
+	db.Model(&APIKey{}).Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).Count(&count)
+	if count >= apiKeyMaxPerUser {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("最多同时持有 %d 个 API Key", apiKeyMaxPerUser)})
+		return
+	}
+
+	secret, _ := newRefreshToken()
+	prefix := randomString()[:8]
+	key := apiKeyMarker + prefix + "_" + secret
+	apiKey := APIKey{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    apiKeyMarker + prefix,
+		KeyHash:   hashToken(key),
+		Scopes:    req.Scopes,
+		ExpiresAt: time.Now().AddDate(0, 0, req.ExpiresInDays),
+	}
+	if err := db.Create(&apiKey).Error; err != nil {
+		c.JSON(500, gin.H{"error": "创建失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "创建成功，请立即保存，Key 不会再次显示", "key": key, "data": apiKey})
+}
+
+func RevokeAPIKeyHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	res := db.Model(&APIKey{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", c.Param("id"), userID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil || res.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "API Key 不存在"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "已吊销"})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// createTestAPIKey 直接写库创建 API Key，返回完整 Key
+func createTestAPIKey(t *testing.T, user User, scopes []string, revoked bool) string {
+	t.Helper()
+	secret, _ := newRefreshToken()
+	prefix := randomString()[:8]
+	key := apiKeyMarker + prefix + "_" + secret
+	apiKey := APIKey{
+		UserID:    user.ID,
+		Name:      "test",
+		Prefix:    apiKeyMarker + prefix,
+		KeyHash:   hashToken(key),
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if revoked {
+		now := time.Now()
+		apiKey.RevokedAt = &now
+	}
+	if err := db.Create(&apiKey).Error; err != nil {
+		t.Fatalf("创建 API Key 失败: %v", err)
+	}
+	return key
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	student := createTestUser(t, "student", "student")
+	course := createTestCourse(t, createTestUser(t, "teacher", "teacher"))
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		revoked bool
+		// header 为 true 时通过 X-API-Key 发送，否则放在 Authorization: Bearer 中
+		header bool
+		want   int
+	}{
+		{"只读访问", http.MethodGet, "/api/v1/my-courses", false, false, 200},
+		{"X-API-Key 请求头", http.MethodGet, "/api/v1/my-courses", false, true, 200},
+		{"超出权限范围", http.MethodPost, "/api/v1/enroll", false, false, 403},
+		{"不能访问账号接口", http.MethodGet, "/api/v1/user/profile", false, false, 403},
+		{"已吊销", http.MethodGet, "/api/v1/my-courses", true, false, 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := createTestAPIKey(t, student, []string{"read"}, tt.revoked)
+			var body interface{}
+			if tt.method == http.MethodPost {
+				body = map[string]interface{}{"course_id": course.ID}
+			}
+			var req *http.Request
+			if tt.header {
+				req = newJSONRequest(tt.method, tt.path, "", body)
+				req.Header.Set("X-API-Key", key)
+			} else {
+				req = newJSONRequest(tt.method, tt.path, key, body)
+			}
+			w := serve(r, req)
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 课程作业（一门课可布置多次）
+// ===========================
+
+// 迟交策略
+const (
+	LatePolicyFlag   = "flag"   // 允许迟交，但标记为迟交
+	LatePolicyReject = "reject" // 截止后拒绝提交
+)
+
+type Assignment struct {
+	gorm.Model
+	CourseID     uint       `gorm:"index" json:"course_id"`
+	TeacherID    uint       `json:"teacher_id"`
+	Title        string     `json:"title"`
+	Instructions string     `json:"instructions" gorm:"type:text"`
+	DueAt        *time.Time `json:"due_at"`
+	MaxScore     int        `json:"max_score" gorm:"default:100"`
+	LatePolicy   string     `json:"late_policy" gorm:"default:flag"`
+}
+
+type AssignmentReq struct {
+	Title        string     `json:"title"`
+	Instructions string     `json:"instructions"`
+	DueAt        *time.Time `json:"due_at"`
+	MaxScore     int        `json:"max_score"`
+	LatePolicy   string     `json:"late_policy"`
+	// ClearDueAt 为 true 时取消截止时间，仅修改时使用
+	ClearDueAt bool `json:"clear_due_at"`
+}
+
+// IsClosed 判断当前时间提交是否已过截止时间
+func (a Assignment) IsClosed(now time.Time) bool {
+	return a.DueAt != nil && now.After(*a.DueAt)
+}
+
+func validLatePolicy(p string) bool {
+	return p == LatePolicyFlag || p == LatePolicyReject
+}
+
+// migrateHomeworkReq 把旧版 Course.HomeworkReq 及已有提交迁移为课程的默认作业
+func migrateHomeworkReq() {
+	var courses []Course
+	db.Where("(homework_req IS NOT NULL AND homework_req <> '') OR id IN (?)",
+		db.Model(&Homework{}).Select("course_id").Where("assignment_id = 0 OR assignment_id IS NULL")).Find(&courses)
+	for _, course := range courses {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			assignment, err := defaultAssignment(tx, course)
+			if err != nil {
+				return err
+			}
+			return tx.Model(&Homework{}).
+				Where("course_id = ? AND (assignment_id = 0 OR assignment_id IS NULL)", course.ID).
+				Update("assignment_id", assignment.ID).Error
+		})
+		if err != nil {
+			log.Printf("⚠️ 课程 %d 作业迁移失败: %v", course.ID, err)
+		}
+	}
+}
+
+// defaultAssignment 返回课程最早布置的作业，没有时根据 HomeworkReq 创建一份
+func defaultAssignment(tx *gorm.DB, course Course) (Assignment, error) {
+	var assignment Assignment
+	err := tx.Where("course_id = ?", course.ID).Order("id asc").First(&assignment).Error
+	if err == nil {
+		return assignment, nil
+	}
+	assignment = Assignment{
+		CourseID:     course.ID,
+		TeacherID:    course.TeacherID,
+		Title:        "课后作业",
+		Instructions: course.HomeworkReq,
+		MaxScore:     100,
+		LatePolicy:   LatePolicyFlag,
+	}
+	return assignment, tx.Create(&assignment).Error
+}
+
+func loadAssignment(c *gin.Context, course Course) (Assignment, bool) {
+	var assignment Assignment
+	aid, ok := paramID(c, "aid")
+	if !ok {
+		return assignment, false
+	}
+	if err := db.Where("course_id = ?", course.ID).First(&assignment, aid).Error; err != nil {
+		c.JSON(404, gin.H{"error": "作业不存在"})
+		return assignment, false
+	}
+	return assignment, true
+}
+
+func ListAssignmentsHandler(c *gin.Context) {
+	var assignments []Assignment
+	db.Where("course_id = ?", c.Param("id")).Order("id asc").Find(&assignments)
+	c.JSON(200, gin.H{"data": assignments})
+}
+
+func CreateAssignmentHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	var req AssignmentReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Title == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if req.MaxScore <= 0 {
+		req.MaxScore = 100
+	}
+	if req.LatePolicy == "" {
+		req.LatePolicy = LatePolicyFlag
+	}
+	if !validLatePolicy(req.LatePolicy) {
+		c.JSON(400, gin.H{"error": "迟交策略无效"})
+		return
+	}
+	assignment := Assignment{
+		CourseID:     course.ID,
+		TeacherID:    course.TeacherID,
+		Title:        req.Title,
+		Instructions: req.Instructions,
+		DueAt:        req.DueAt,
+		MaxScore:     req.MaxScore,
+		LatePolicy:   req.LatePolicy,
+	}
+	db.Create(&assignment)
+	c.JSON(200, gin.H{"message": "布置成功", "data": assignment})
+}
+
+func UpdateAssignmentHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	assignment, ok := loadAssignment(c, course)
+	if !ok {
+		return
+	}
+	var req AssignmentReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if req.LatePolicy != "" && !validLatePolicy(req.LatePolicy) {
+		c.JSON(400, gin.H{"error": "迟交策略无效"})
+		return
+	}
+	if req.MaxScore < 0 {
+		c.JSON(400, gin.H{"error": "满分必须大于0"})
+		return
+	}
+	updates := map[string]interface{}{}
+	if req.Title != "" {
+		updates["title"] = req.Title
+	}
+	if req.Instructions != "" {
+		updates["instructions"] = req.Instructions
+	}
+	if req.MaxScore > 0 {
+		updates["max_score"] = req.MaxScore
+	}
+	if req.LatePolicy != "" {
+		updates["late_policy"] = req.LatePolicy
+	}
+	// 未传 due_at 时保留原截止时间，清空需显式传 clear_due_at
+	if req.ClearDueAt {
+		updates["due_at"] = nil
+	} else if req.DueAt != nil {
+		updates["due_at"] = req.DueAt
+	}
+	db.Model(&assignment).Updates(updates)
+	c.JSON(200, gin.H{"message": "更新成功"})
+}
+
+func DeleteAssignmentHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	assignment, ok := loadAssignment(c, course)
+	if !ok {
+		return
+	}
+	var count int64
+	db.Model(&Homework{}).Where("assignment_id = ?", assignment.ID).Count(&count)
+	if count > 0 {
+		c.JSON(400, gin.H{"error": "已有学生提交，无法删除"})
+		return
+	}
+	db.Delete(&assignment)
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateAssignmentPartial(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	teacher := createTestUser(t, "teacher", "teacher")
+	token := loginAs(t, teacher)
+	course := createTestCourse(t, teacher)
+	due := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	later := due.Add(48 * time.Hour)
+
+	tests := []struct {
+		name       string
+		body       map[string]interface{}
+		wantTitle  string
+		wantDueAt  *time.Time
+		wantMax    int
+		wantPolicy string
+		wantStatus int
+	}{
+		{"只改标题，截止时间不变", map[string]interface{}{"title": "新标题"}, "新标题", &due, 100, LatePolicyReject, 200},
+		{"只改满分", map[string]interface{}{"max_score": 50}, "作业一", &due, 50, LatePolicyReject, 200},
+		{"修改截止时间", map[string]interface{}{"due_at": later}, "作业一", &later, 100, LatePolicyReject, 200},
+		{"显式清空截止时间", map[string]interface{}{"clear_due_at": true}, "作业一", nil, 100, LatePolicyReject, 200},
+		{"迟交策略无效", map[string]interface{}{"late_policy": "whatever"}, "作业一", &due, 100, LatePolicyReject, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assignment := Assignment{CourseID: course.ID, TeacherID: teacher.ID, Title: "作业一",
+				DueAt: &due, MaxScore: 100, LatePolicy: LatePolicyReject}
+			db.Create(&assignment)
+
+			w := doRequest(r, http.MethodPut, fmt.Sprintf("/api/v1/courses/%d/assignments/%d", course.ID, assignment.ID), token, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			var got Assignment
+			db.First(&got, assignment.ID)
+			if got.Title != tt.wantTitle || got.MaxScore != tt.wantMax || got.LatePolicy != tt.wantPolicy {
+				t.Errorf("标题/满分/迟交策略 = %q/%d/%q，期望 %q/%d/%q",
+					got.Title, got.MaxScore, got.LatePolicy, tt.wantTitle, tt.wantMax, tt.wantPolicy)
+			}
+			switch {
+			case tt.wantDueAt == nil && got.DueAt != nil:
+				t.Errorf("截止时间 = %v，期望已清空", *got.DueAt)
+			case tt.wantDueAt != nil && (got.DueAt == nil || !got.DueAt.Equal(*tt.wantDueAt)):
+				t.Errorf("截止时间 = %v，期望 %v", got.DueAt, *tt.wantDueAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 操作审计日志
+// ===========================
+
+const (
+	AuditCourseAudit     = "course.audit"
+	AuditCourseUpdate    = "course.update"
+	AuditHomeworkGrade   = "homework.grade"
+	AuditQuestionReply   = "question.reply"
+	AuditUserCreate      = "user.create"
+	AuditUserUpdate      = "user.update"
+	AuditUserDelete      = "user.delete"
+	AuditUserErase       = "user.erase"
+	AuditUserUnlock      = "user.unlock"
+	AuditUserRole        = "user.role"
+	AuditUserDisable     = "user.disable"
+	AuditUserEnable      = "user.enable"
+	AuditUserResetPwd    = "user.reset_password"
+	AuditImpersonate     = "user.impersonate"
+	AuditRoleCreate      = "role.create"
+	AuditRoleUpdate      = "role.update"
+	AuditRoleDelete      = "role.delete"
+	AuditTeacherReview   = "teacher.review"
+	auditExportMaxRows   = 50000
+	auditTimeLayout      = "2006-01-02 15:04:05"
+	auditQueryDateLayout = "2006-01-02"
+)
+
+var errAuditImmutable = errors.New("审计日志不允许修改或删除")
+
+// AuditEvent 特权操作记录，只允许追加。Before/After 为操作前后目标的 JSON 快照
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	ActorID   uint      `gorm:"index" json:"actor_id"`
+	ActorName string    `json:"actor_name"`
+	// 模拟登录或 API Key 发起的操作，记录实际的管理员和所用的 Key
+	ImpersonatorID *uint  `json:"impersonator_id"`
+	APIKeyID       *uint  `json:"api_key_id"`
+	Action         string `gorm:"size:64;index" json:"action"`
+	TargetType     string `gorm:"size:32;index:idx_audit_target" json:"target_type"`
+	TargetID       uint   `gorm:"index:idx_audit_target" json:"target_id"`
+	Before         string `gorm:"type:text" json:"before"`
+	After          string `gorm:"type:text" json:"after"`
+	IP             string `gorm:"size:64" json:"ip"`
+}
+
+func (AuditEvent) BeforeUpdate(*gorm.DB) error { return errAuditImmutable }
+func (AuditEvent) BeforeDelete(*gorm.DB) error { return errAuditImmutable }
+
+// recordAudit 在业务操作所在的事务中记录一次特权操作，写入失败时整个操作回滚
+func recordAudit(tx *gorm.DB, c *gin.Context, action, targetType string, targetID uint, before, after interface{}) error {
+	event := AuditEvent{
+		ActorID:    c.MustGet("userID").(uint),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     auditJSON(before),
+		After:      auditJSON(after),
+		IP:         c.ClientIP(),
+	}
+	var actor User
+	if tx.Unscoped().Select("username").First(&actor, event.ActorID).Error == nil {
+		event.ActorName = actor.Username
+	}
+	if v, ok := c.Get("actorID"); ok {
+		id := v.(uint)
+		event.ImpersonatorID = &id
+	}
+	if v, ok := c.Get("apiKeyID"); ok {
+		id := v.(uint)
+		event.APIKeyID = &id
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		log.Printf("⚠️ 审计日志写入失败 %s %s#%d: %v", action, targetType, targetID, err)
+		return err
+	}
+	return nil
+}
+
+func auditJSON(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// 审计快照只保留业务字段，不带关联数据
+
+func courseSnapshot(course Course) gin.H {
+	return gin.H{
+		"title":        course.Title,
+		"description":  course.Description,
+		"cover_image":  course.CoverImage,
+		"video_url":    course.VideoURL,
+		"price":        course.Price,
+		"category":     course.Category,
+		"homework_req": course.HomeworkReq,
+		"status":       course.Status,
+	}
+}
+
+func homeworkSnapshot(hw Homework) gin.H {
+	return gin.H{
+		"score":           hw.Score,
+		"comment":         hw.Comment,
+		"status":          hw.Status,
+		"grader_id":       hw.GraderID,
+		"graded_revision": hw.GradedRevision,
+	}
+}
+
+func questionSnapshot(q Question) gin.H {
+	return gin.H{"answer": q.Answer, "teacher_id": q.TeacherID, "is_answered": q.IsAnswered}
+}
+
+// auditQuery 根据查询参数构造筛选条件，from/to 为 YYYY-MM-DD，to 当天包含在内
+func auditQuery(c *gin.Context) (*gorm.DB, error) {
+	tx := db.Model(&AuditEvent{})
+	for _, key := range []string{"actor_id", "action", "target_type", "target_id"} {
+		if v := c.Query(key); v != "" {
+			tx = tx.Where(key+" = ?", v)
+		}
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := time.ParseInLocation(auditQueryDateLayout, v, time.Local)
+		if err != nil {
+			return nil, errors.New("from 格式应为 YYYY-MM-DD")
+		}
+		tx = tx.Where("created_at >= ?", from)
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.ParseInLocation(auditQueryDateLayout, v, time.Local)
+		if err != nil {
+			return nil, errors.New("to 格式应为 YYYY-MM-DD")
+		}
+		tx = tx.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+	return tx, nil
+}
+
+// ListAuditEventsHandler 查询审计日志，format=csv 时按筛选条件导出全部记录
+func ListAuditEventsHandler(c *gin.Context) {
+	tx, err := auditQuery(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		page, pageSize := pageParams(c)
+		var total int64
+		tx.Count(&total)
+		var events []AuditEvent
+		tx.Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&events)
+		c.JSON(200, gin.H{"data": events, "total": total, "page": page, "page_size": pageSize})
+	case "csv":
+		var events []AuditEvent
+		tx.Order("id").Limit(auditExportMaxRows).Find(&events)
+		var buf bytes.Buffer
+		buf.WriteString("\xEF\xBB\xBF") // UTF-8 BOM，保证 Excel 直接打开中文不乱码
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"ID", "时间", "操作人ID", "操作人", "模拟登录管理员ID", "API Key ID", "操作", "对象类型", "对象ID", "操作前", "操作后", "IP"})
+		for _, e := range events {
+			w.Write([]string{
+				strconv.FormatUint(uint64(e.ID), 10),
+				e.CreatedAt.Format(auditTimeLayout),
+				strconv.FormatUint(uint64(e.ActorID), 10),
+				spreadsheetSafe(e.ActorName),
+				optionalID(e.ImpersonatorID),
+				optionalID(e.APIKeyID),
+				e.Action,
+				e.TargetType,
+				strconv.FormatUint(uint64(e.TargetID), 10),
+				spreadsheetSafe(e.Before),
+				spreadsheetSafe(e.After),
+				e.IP,
+			})
+		}
+		w.Flush()
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit_%s.csv"`, time.Now().Format("20060102150405")))
+		c.Data(200, "text/csv; charset=utf-8", buf.Bytes())
+	default:
+		c.JSON(400, gin.H{"error": "不支持的导出格式"})
+	}
+}
+
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	student := createTestUser(t, "student", "student")
+	other := createTestUser(t, "other", "student")
+
+	tests := []struct {
+		name  string
+		token func(t *testing.T) string
+		want  int
+	}{
+		{"有效会话", func(t *testing.T) string { return loginAs(t, student) }, 200},
+		{"未登录", func(t *testing.T) string { return "" }, 401},
+		{"缺少 sid 的旧令牌", func(t *testing.T) string {
+			token, _ := signJWT(jwt.MapClaims{"user_id": student.ID, "role": "student", "version": 0,
+				"exp": time.Now().Add(time.Hour).Unix()})
+			return token
+		}, 401},
+		{"版本号过期", func(t *testing.T) string {
+			stale := student
+			stale.TokenVersion = 5
+			return loginAs(t, stale)
+		}, 401},
+		{"会话已吊销", func(t *testing.T) string {
+			token := loginAs(t, student)
+			db.Model(&Session{}).Where("user_id = ?", student.ID).Update("revoked_at", time.Now())
+			return token
+		}, 401},
+		{"会话属于其他用户", func(t *testing.T) string {
+			token, _ := GenerateToken(student.ID, "student", 0, createTestSession(t, other).ID)
+			return token
+		}, 401},
+		{"两步验证临时令牌", func(t *testing.T) string {
+			token, _ := signJWT(jwt.MapClaims{"user_id": student.ID, "purpose": "mfa", "sid": 1,
+				"exp": time.Now().Add(time.Hour).Unix()})
+			return token
+		}, 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, http.MethodGet, "/api/v1/my-courses", tt.token(t), nil)
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRegisterPasswordLength(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+
+	tests := []struct {
+		name, username, password string
+		want                     int
+	}{
+		{"密码过短", "short", "12345", 400},
+		{"空密码", "empty", "", 400},
+		{"密码长度足够", "ok", testPassword, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, http.MethodPost, "/api/v1/register", "", map[string]string{"username": tt.username, "password": tt.password})
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
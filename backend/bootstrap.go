@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 管理员初始化
+// ===========================
+
+// createAdmin 创建管理员账号，用户名已存在时报错，不会修改已有账号
+func createAdmin(username, password string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return errors.New("用户名不能为空")
+	}
+	if len(password) < minPasswordLen {
+		return fmt.Errorf("密码至少 %d 位", minPasswordLen)
+	}
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	var existing User
+	if err := db.Unscoped().Where("username = ?", username).First(&existing).Error; err == nil {
+		// 因旧默认密码被停用的管理员，用新密码恢复原账号
+		if existing.Role != "admin" || existing.DeletedAt.Valid || existing.DisabledReason != legacyAdminDisabledReason {
+			return fmt.Errorf("用户名 %s 已存在", username)
+		}
+		return db.Model(&existing).Updates(map[string]interface{}{
+			"password":             string(hashedPwd),
+			"disabled_at":          nil,
+			"disabled_reason":      "",
+			"must_change_password": false,
+			"token_version":        gorm.Expr("token_version + 1"),
+		}).Error
+	}
+	return db.Create(&User{Username: username, Password: string(hashedPwd), Role: "admin"}).Error
+}
+
+// legacyAdminPassword 旧版本每次启动都会把 admin 的密码重置为它
+const legacyAdminPassword = "123456"
+
+// legacyAdminDisabledReason 因仍在使用旧默认密码而被停用的管理员
+const legacyAdminDisabledReason = "仍在使用旧默认密码"
+
+// flagLegacyAdminPassword 仍在使用旧默认密码的管理员直接停用并作废密码，
+// 只能通过 ADMIN_USERNAME / ADMIN_PASSWORD 或 `server create-admin` 设置新密码恢复；
+// 仅要求改密不够，任何知道默认密码的人都可以抢先登录改掉它
+func flagLegacyAdminPassword() {
+	var admins []User
+	db.Select("id", "username", "password").Where("role = ? AND disabled_at IS NULL", "admin").Find(&admins)
+	for _, admin := range admins {
+		if bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(legacyAdminPassword)) != nil {
+			continue
+		}
+		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(randomString()), bcrypt.DefaultCost)
+		if err == nil {
+			err = db.Model(&admin).Updates(map[string]interface{}{
+				"password":        string(hashedPwd),
+				"disabled_at":     time.Now(),
+				"disabled_reason": legacyAdminDisabledReason,
+				"token_version":   gorm.Expr("token_version + 1"),
+			}).Error
+		}
+		if err != nil {
+			log.Printf("⚠️⚠️⚠️ 管理员 %s 仍在使用默认密码 %s，且无法停用该账号: %v", admin.Username, legacyAdminPassword, err)
+			continue
+		}
+		log.Printf("⚠️⚠️⚠️ 管理员 %s 仍在使用默认密码 %s，已停用该账号并作废其密码。"+
+			"请设置 ADMIN_USERNAME=%s 和 ADMIN_PASSWORD 后重启，或执行 `server create-admin -username %s` 设置新密码以恢复",
+			admin.Username, legacyAdminPassword, admin.Username, admin.Username)
+	}
+}
+
+// bootstrapAdmin 库中还没有任何可用的管理员时，使用 ADMIN_USERNAME / ADMIN_PASSWORD 创建第一个管理员；
+// 之后每次启动都不再改动管理员账号
+func bootstrapAdmin() {
+	flagLegacyAdminPassword()
+	var count int64
+	db.Model(&User{}).Where("role = ? AND disabled_at IS NULL", "admin").Count(&count)
+	if count > 0 {
+		return
+	}
+	username, password := os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")
+	if username == "" || password == "" {
+		log.Printf("⚠️ 尚无可用的管理员账号，请设置 ADMIN_USERNAME / ADMIN_PASSWORD 后重启，或执行 `server create-admin`")
+		return
+	}
+	if err := createAdmin(username, password); err != nil {
+		log.Printf("⚠️ 初始化管理员失败: %v", err)
+		return
+	}
+	log.Printf("✅ 已创建管理员 %s", username)
+}
+
+// runCreateAdmin 命令行子命令：server create-admin -username xxx [-password xxx]，
+// 未指定密码时从标准输入读取，避免密码留在 shell 历史里
+func runCreateAdmin(args []string) {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	username := fs.String("username", "admin", "管理员用户名")
+	password := fs.String("password", "", "管理员密码，不填则从标准输入读取")
+	fs.Parse(args)
+
+	if *password == "" {
+		fmt.Print("请输入密码: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("❌ 读取密码失败: %v", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	initConfig()
+	initDB()
+	if err := createAdmin(*username, *password); err != nil {
+		log.Fatalf("❌ 创建管理员失败: %v", err)
+	}
+	fmt.Printf("已创建管理员 %s\n", *username)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestFlagLegacyAdminPassword(t *testing.T) {
+	setupTestDB(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte(legacyAdminPassword), bcrypt.MinCost)
+	legacy := User{Username: "admin", Password: string(hash), Role: "admin"}
+	db.Create(&legacy)
+	other := createTestUser(t, "root", "admin")
+
+	flagLegacyAdminPassword()
+
+	var got User
+	db.First(&got, legacy.ID)
+	if !got.disabled() || got.TokenVersion != 1 ||
+		bcrypt.CompareHashAndPassword([]byte(got.Password), []byte(legacyAdminPassword)) == nil {
+		t.Fatal("仍在使用默认密码的管理员没有被停用并作废密码")
+	}
+	var kept User
+	db.First(&kept, other.ID)
+	if kept.disabled() {
+		t.Fatal("使用其他密码的管理员不应被停用")
+	}
+
+	// 只有通过 create-admin 设置新密码才能恢复
+	if err := createAdmin("root", "newpassword"); err == nil {
+		t.Fatal("不应覆盖正常的已有账号")
+	}
+	if err := createAdmin("admin", "newpassword"); err != nil {
+		t.Fatalf("恢复被停用的管理员失败: %v", err)
+	}
+	var restored User
+	db.First(&restored, legacy.ID)
+	if restored.disabled() || bcrypt.CompareHashAndPassword([]byte(restored.Password), []byte("newpassword")) != nil {
+		t.Fatal("管理员没有用新密码恢复")
+	}
+}
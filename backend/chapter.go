@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 课程章节 / 课时
+// ===========================
+
+type Chapter struct {
+	gorm.Model
+	CourseID  uint     `gorm:"index" json:"course_id"`
+	Title     string   `json:"title"`
+	Desc      string   `json:"desc" gorm:"type:text"`
+	SortOrder int      `json:"sort_order"`
+	Lessons   []Lesson `gorm:"foreignKey:ChapterID" json:"lessons"`
+}
+
+type Lesson struct {
+	gorm.Model
+	ChapterID   uint               `gorm:"index" json:"chapter_id"`
+	Title       string             `json:"title"`
+	VideoURL    string             `json:"video_url"`
+	Duration    int                `json:"duration"` // 时长，单位秒
+	SortOrder   int                `json:"sort_order"`
+	Attachments []LessonAttachment `gorm:"foreignKey:LessonID" json:"attachments"`
+}
+
+type LessonAttachment struct {
+	gorm.Model
+	LessonID uint   `gorm:"index" json:"lesson_id"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+}
+
+type AttachmentReq struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type LessonReq struct {
+	Title       string          `json:"title"`
+	VideoURL    string          `json:"video_url"`
+	Duration    int             `json:"duration"`
+	Attachments []AttachmentReq `json:"attachments"`
+}
+
+type ReorderReq struct {
+	IDs []uint `json:"ids"`
+}
+
+// preloadChapters 按排序号加载章节、课时及附件
+func preloadChapters(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Chapters", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order asc, id asc")
+	}).Preload("Chapters.Lessons", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order asc, id asc")
+	}).Preload("Chapters.Lessons.Attachments")
+}
+
+// importOutline 把旧版 Outline JSON 转换为章节记录，仅在课程还没有章节时执行。
+// 导入后清空课程的 Outline，之后章节只通过章节接口维护
+func importOutline(tx *gorm.DB, courseID uint, outline string) error {
+	items := parseOutline(outline)
+	return tx.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&Chapter{}).Where("course_id = ?", courseID).Count(&count)
+		if count == 0 && len(items) > 0 {
+			chapters := make([]Chapter, 0, len(items))
+			for i, item := range items {
+				chapters = append(chapters, Chapter{CourseID: courseID, Title: item.Title, Desc: item.Desc, SortOrder: i})
+			}
+			if err := tx.Create(&chapters).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&Course{}).Where("id = ?", courseID).Update("outline", "").Error
+	})
+}
+
+// migrateOutlines 启动时把存量课程的大纲迁移成章节，已迁移的课程 Outline 为空，不会重复导入
+func migrateOutlines() {
+	var courses []Course
+	db.Select("id", "outline").Where("outline IS NOT NULL AND outline <> ''").Find(&courses)
+	for _, course := range courses {
+		if err := importOutline(db, course.ID, course.Outline); err != nil {
+			log.Printf("⚠️ 课程 %d 大纲迁移失败: %v", course.ID, err)
+		}
+	}
+}
+
+// courseChapterIDs 按章节顺序返回课程的章节 ID
+func courseChapterIDs(tx *gorm.DB, courseID uint) []uint {
+	var ids []uint
+	tx.Model(&Chapter{}).Where("course_id = ?", courseID).Order("sort_order asc, id asc").Pluck("id", &ids)
+	return ids
+}
+
+// loadManagedCourse 加载路由中的课程并校验当前用户是授课教师或管理员，失败时已写入响应
+func loadManagedCourse(c *gin.Context) (Course, bool) {
+	var course Course
+	id, ok := paramID(c, "id")
+	if !ok {
+		return course, false
+	}
+	if err := db.First(&course, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "课程不存在"})
+		return course, false
+	}
+	userID := c.MustGet("userID").(uint)
+	if course.TeacherID != userID && !can(c, PermCourseManageAny) {
+		c.JSON(403, gin.H{"error": "权限不足"})
+		return course, false
+	}
+	return course, true
+}
+
+func loadChapter(c *gin.Context, course Course) (Chapter, bool) {
+	var chapter Chapter
+	id, ok := paramID(c, "cid")
+	if !ok {
+		return chapter, false
+	}
+	if err := db.Where("course_id = ?", course.ID).First(&chapter, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "章节不存在"})
+		return chapter, false
+	}
+	return chapter, true
+}
+
+func loadLesson(c *gin.Context, chapter Chapter) (Lesson, bool) {
+	var lesson Lesson
+	id, ok := paramID(c, "lid")
+	if !ok {
+		return lesson, false
+	}
+	if err := db.Where("chapter_id = ?", chapter.ID).First(&lesson, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "课时不存在"})
+		return lesson, false
+	}
+	return lesson, true
+}
+
+func toAttachments(reqs []AttachmentReq, lessonID uint) []LessonAttachment {
+	list := make([]LessonAttachment, 0, len(reqs))
+	for _, a := range reqs {
+		if a.URL == "" {
+			continue
+		}
+		list = append(list, LessonAttachment{LessonID: lessonID, Name: a.Name, URL: a.URL})
+	}
+	return list
+}
+
+// reorder 按 ids 给出的顺序重写 sort_order，ids 必须恰好覆盖 scope 下的全部记录
+func reorder(model interface{}, scope string, scopeID uint, ids []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing []uint
+		tx.Model(model).Where(scope+" = ?", scopeID).Pluck("id", &existing)
+		if len(existing) != len(ids) {
+			return errors.New("排序列表与现有记录不一致")
+		}
+		seen := make(map[uint]bool, len(existing))
+		for _, id := range existing {
+			seen[id] = true
+		}
+		for i, id := range ids {
+			if !seen[id] {
+				return errors.New("排序列表包含无效的ID: " + strconv.FormatUint(uint64(id), 10))
+			}
+			delete(seen, id)
+			if err := tx.Model(model).Where("id = ?", id).Update("sort_order", i).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func ListChaptersHandler(c *gin.Context) {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	var course Course
+	if err := preloadChapters(db).First(&course, id).Error; err != nil || !courseVisible(c, course) {
+		c.JSON(404, gin.H{"error": "课程不存在"})
+		return
+	}
+	c.JSON(200, gin.H{"data": course.Chapters})
+}
+
+func CreateChapterHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Title string `json:"title"`
+		Desc  string `json:"desc"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Title == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	var maxOrder int
+	db.Model(&Chapter{}).Where("course_id = ?", course.ID).Select("COALESCE(MAX(sort_order), -1)").Scan(&maxOrder)
+	chapter := Chapter{CourseID: course.ID, Title: req.Title, Desc: req.Desc, SortOrder: maxOrder + 1}
+	db.Create(&chapter)
+	c.JSON(200, gin.H{"message": "添加成功", "data": chapter})
+}
+
+func UpdateChapterHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	chapter, ok := loadChapter(c, course)
+	if !ok {
+		return
+	}
+	var req struct {
+		Title string `json:"title"`
+		Desc  string `json:"desc"`
+	}
+	c.ShouldBindJSON(&req)
+	db.Model(&chapter).Updates(Chapter{Title: req.Title, Desc: req.Desc})
+	c.JSON(200, gin.H{"message": "更新成功"})
+}
+
+func DeleteChapterHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	chapter, ok := loadChapter(c, course)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var lessonIDs []uint
+		tx.Model(&Lesson{}).Where("chapter_id = ?", chapter.ID).Pluck("id", &lessonIDs)
+		if len(lessonIDs) > 0 {
+			if err := tx.Where("lesson_id IN ?", lessonIDs).Delete(&LessonAttachment{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", lessonIDs).Delete(&Lesson{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&chapter).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
+
+func ReorderChaptersHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	var req ReorderReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := reorder(&Chapter{}, "course_id", course.ID, req.IDs); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "排序已更新"})
+}
+
+func CreateLessonHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	chapter, ok := loadChapter(c, course)
+	if !ok {
+		return
+	}
+	var req LessonReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Title == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	var maxOrder int
+	db.Model(&Lesson{}).Where("chapter_id = ?", chapter.ID).Select("COALESCE(MAX(sort_order), -1)").Scan(&maxOrder)
+	lesson := Lesson{
+		ChapterID: chapter.ID,
+		Title:     req.Title,
+		VideoURL:  req.VideoURL,
+		Duration:  req.Duration,
+		SortOrder: maxOrder + 1,
+	}
+	lesson.Attachments = toAttachments(req.Attachments, 0)
+	if err := db.Create(&lesson).Error; err != nil {
+		c.JSON(500, gin.H{"error": "添加失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "添加成功", "data": lesson})
+}
+
+func UpdateLessonHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	chapter, ok := loadChapter(c, course)
+	if !ok {
+		return
+	}
+	lesson, ok := loadLesson(c, chapter)
+	if !ok {
+		return
+	}
+	var req LessonReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&lesson).Updates(Lesson{Title: req.Title, VideoURL: req.VideoURL, Duration: req.Duration}).Error; err != nil {
+			return err
+		}
+		// 附件列表整体替换；未传 attachments 字段时保持不变
+		if req.Attachments == nil {
+			return nil
+		}
+		if err := tx.Where("lesson_id = ?", lesson.ID).Delete(&LessonAttachment{}).Error; err != nil {
+			return err
+		}
+		if list := toAttachments(req.Attachments, lesson.ID); len(list) > 0 {
+			return tx.Create(&list).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "更新失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "更新成功"})
+}
+
+func DeleteLessonHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	chapter, ok := loadChapter(c, course)
+	if !ok {
+		return
+	}
+	lesson, ok := loadLesson(c, chapter)
+	if !ok {
+		return
+	}
+	db.Where("lesson_id = ?", lesson.ID).Delete(&LessonAttachment{})
+	db.Delete(&lesson)
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
+
+func ReorderLessonsHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	chapter, ok := loadChapter(c, course)
+	if !ok {
+		return
+	}
+	var req ReorderReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := reorder(&Lesson{}, "chapter_id", chapter.ID, req.IDs); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "排序已更新"})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCourseVisibility(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	teacher := createTestUser(t, "teacher", "teacher")
+	published := createTestCourse(t, teacher)
+	pending := Course{Title: "待审核", TeacherID: teacher.ID}
+	db.Create(&pending)
+	db.Model(&pending).Update("status", 0)
+	db.Create(&Chapter{CourseID: pending.ID, Title: "未公开的章节"})
+	studentToken := loginAs(t, createTestUser(t, "student", "student"))
+	adminToken := loginAs(t, createTestUser(t, "admin", "admin"))
+
+	tests := []struct {
+		name  string
+		id    string
+		token string
+		want  int
+	}{
+		{"已上线课程公开可见", fmt.Sprint(published.ID), "", 200},
+		{"未上线课程匿名不可见", fmt.Sprint(pending.ID), "", 404},
+		{"未上线课程其他学生不可见", fmt.Sprint(pending.ID), studentToken, 404},
+		{"授课教师可以预览", fmt.Sprint(pending.ID), loginAs(t, teacher), 200},
+		{"审核人员可以预览", fmt.Sprint(pending.ID), adminToken, 200},
+		{"非数字 ID", url.PathEscape(fmt.Sprintf("%d OR 1=1", pending.ID)), "", 400},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/api/v1/courses/%s", "/api/v1/courses/%s/chapters"} {
+			t.Run(tt.name+path[len("/api/v1/courses/%s"):], func(t *testing.T) {
+				w := doRequest(r, http.MethodGet, fmt.Sprintf(path, tt.id), tt.token, nil)
+				if w.Code != tt.want {
+					t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ===========================
+// 限时考试
+// ===========================
+
+const (
+	// 截止后仍接受提交的宽限时间，用于抵消网络延迟
+	examGracePeriod = 5 * time.Second
+	// 后台扫描超时未交卷作答的间隔
+	examSweepInterval = 30 * time.Second
+)
+
+// expired 判断限时作答是否已超过截止时间（含宽限）
+func (a QuizAttempt) expired(now time.Time) bool {
+	return a.Deadline != nil && now.After(a.Deadline.Add(examGracePeriod))
+}
+
+// savedAnswers 返回最后一次自动保存的答案
+func (a QuizAttempt) savedAnswers() map[string][]string {
+	answers := map[string][]string{}
+	if a.Answers != "" {
+		json.Unmarshal([]byte(a.Answers), &answers)
+	}
+	return answers
+}
+
+// attemptState 返回作答中的题目、已保存答案和剩余时间
+func attemptState(attempt QuizAttempt) gin.H {
+	views := []QuestionView{}
+	for _, q := range attemptQuestions(attempt) {
+		views = append(views, toQuestionView(q))
+	}
+	state := gin.H{
+		"attempt_id": attempt.ID,
+		"questions":  views,
+		"answers":    attempt.savedAnswers(),
+		"deadline":   attempt.Deadline,
+	}
+	if attempt.Deadline != nil {
+		state["remaining_seconds"] = max(int(time.Until(*attempt.Deadline).Seconds()), 0)
+	}
+	return state
+}
+
+// SaveAttemptAnswersHandler 自动保存作答；超时后拒绝保存并按已保存的答案交卷
+func SaveAttemptAnswersHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req struct {
+		Answers map[string][]string `json:"answers"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	var attempt QuizAttempt
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.Where("student_id = ?", userID).First(&attempt, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "作答记录不存在"})
+		return
+	}
+	if attempt.SubmittedAt != nil {
+		c.JSON(409, gin.H{"error": "已交卷"})
+		return
+	}
+	if attempt.expired(time.Now()) {
+		result, err := autoSubmitAttempt(attempt)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "交卷失败"})
+			return
+		}
+		c.JSON(409, gin.H{"error": "考试时间已到，已自动交卷", "result": result})
+		return
+	}
+
+	raw, _ := json.Marshal(req.Answers)
+	res := db.Model(&QuizAttempt{}).Where("id = ? AND submitted_at IS NULL", attempt.ID).Update("answers", string(raw))
+	if res.Error != nil {
+		c.JSON(500, gin.H{"error": "保存失败"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(409, gin.H{"error": "已交卷"})
+		return
+	}
+	resp := gin.H{"message": "已保存"}
+	if attempt.Deadline != nil {
+		resp["remaining_seconds"] = max(int(time.Until(*attempt.Deadline).Seconds()), 0)
+	}
+	c.JSON(200, resp)
+}
+
+// GetQuizAttemptHandler 查询作答状态，用于刷新页面后恢复考试或查看成绩
+func GetQuizAttemptHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var attempt QuizAttempt
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.Where("student_id = ?", userID).First(&attempt, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "作答记录不存在"})
+		return
+	}
+	if attempt.SubmittedAt == nil && attempt.expired(time.Now()) {
+		if _, err := autoSubmitAttempt(attempt); err != nil {
+			c.JSON(500, gin.H{"error": "交卷失败"})
+			return
+		}
+		db.First(&attempt, attempt.ID)
+	}
+	if attempt.SubmittedAt == nil {
+		c.JSON(200, attemptState(attempt))
+		return
+	}
+	_, _, results := gradeAttempt(attemptQuestions(attempt), attempt.savedAnswers())
+	c.JSON(200, gin.H{
+		"attempt_id":   attempt.ID,
+		"score":        attempt.Score,
+		"max_score":    attempt.MaxScore,
+		"submitted_at": attempt.SubmittedAt,
+		"auto_submit":  attempt.AutoSubmit,
+		"results":      results,
+	})
+}
+
+// sweepExpiredAttempts 把已超时仍未交卷的作答按已保存答案交卷
+func sweepExpiredAttempts() {
+	var attempts []QuizAttempt
+	db.Where("submitted_at IS NULL AND auto_submit_failed_at IS NULL AND deadline IS NOT NULL AND deadline < ?", time.Now().Add(-examGracePeriod)).
+		Order("deadline asc").Limit(200).Find(&attempts)
+	for _, attempt := range attempts {
+		autoSubmitAttempt(attempt)
+	}
+}
+
+// autoSubmitAttempt 按已保存的答案为超时的作答交卷，已经交过卷不算失败
+func autoSubmitAttempt(attempt QuizAttempt) (gin.H, error) {
+	result, err := finalizeAttempt(attempt, attempt.savedAnswers(), true)
+	if errors.Is(err, errAttemptSubmitted) {
+		return result, nil
+	}
+	if err != nil {
+		// 标记失败的作答，避免每轮扫描都卡在同一批记录上
+		log.Printf("⚠️ 作答 %d 自动交卷失败: %v", attempt.ID, err)
+		db.Model(&QuizAttempt{}).Where("id = ?", attempt.ID).Update("auto_submit_failed_at", time.Now())
+	}
+	return result, err
+}
+
+// startExamSweeper 启动后台超时交卷；截止时间保存在数据库中，重启后首次扫描即可补交
+func startExamSweeper() {
+	go func() {
+		sweepExpiredAttempts()
+		ticker := time.NewTicker(examSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sweepExpiredAttempts()
+		}
+	}()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// ===========================
+// 成绩册导出
+// ===========================
+
+// buildGradebook 汇总课程所有选课学生的作业成绩、测验成绩和学习进度，返回表头和数据行
+func buildGradebook(course Course) ([]string, [][]string) {
+	var assignments []Assignment
+	db.Where("course_id = ?", course.ID).Order("id asc").Find(&assignments)
+	var quizzes []Quiz
+	db.Where("course_id = ?", course.ID).Order("id asc").Find(&quizzes)
+
+	header := []string{"学生ID", "用户名"}
+	for _, a := range assignments {
+		header = append(header, fmt.Sprintf("%s (满分%d)", a.Title, a.MaxScore))
+	}
+	for _, q := range quizzes {
+		header = append(header, q.Title+" (%)")
+	}
+	header = append(header, "学习进度(%)", "是否完成")
+
+	var enrolls []Enrollment
+	db.Where("course_id = ?", course.ID).Order("user_id asc").Find(&enrolls)
+	userIDs := make([]uint, 0, len(enrolls))
+	for _, e := range enrolls {
+		userIDs = append(userIDs, e.UserID)
+	}
+	usernames := map[uint]string{}
+	var users []User
+	db.Unscoped().Select("id", "username").Where("id IN ?", userIDs).Find(&users)
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+	}
+
+	// scores[学生ID][作业ID]，只统计已批改的作业，退回修改的不计入
+	scores := map[uint]map[uint]int{}
+	var homeworks []Homework
+	db.Where("course_id = ? AND status = ?", course.ID, HomeworkGraded).Find(&homeworks)
+	for _, hw := range homeworks {
+		if scores[hw.StudentID] == nil {
+			scores[hw.StudentID] = map[uint]int{}
+		}
+		scores[hw.StudentID][hw.AssignmentID] = hw.Score
+	}
+
+	rows := make([][]string, 0, len(enrolls))
+	for _, e := range enrolls {
+		row := []string{strconv.FormatUint(uint64(e.UserID), 10), usernames[e.UserID]}
+		for _, a := range assignments {
+			if score, ok := scores[e.UserID][a.ID]; ok {
+				row = append(row, strconv.Itoa(score))
+			} else {
+				row = append(row, "")
+			}
+		}
+		var details ProgressDetails
+		json.Unmarshal([]byte(e.Details), &details)
+		for _, q := range quizzes {
+			if best, ok := details.Quizzes[q.ID]; ok {
+				row = append(row, strconv.FormatFloat(best, 'f', -1, 64))
+			} else {
+				row = append(row, "")
+			}
+		}
+		finish := "否"
+		if e.IsFinish {
+			finish = "是"
+		}
+		row = append(row, strconv.FormatFloat(e.Progress, 'f', -1, 64), finish)
+		rows = append(rows, row)
+	}
+	return header, rows
+}
+
+// spreadsheetSafe 在以 = + - @ 或制表符、回车开头的 CSV 单元格前加单引号，防止表格软件把用户填写的内容当作公式执行。
+// XLSX 的字符串单元格不会被当作公式，不需要也不应该加
+func spreadsheetSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func spreadsheetSafeRow(row []string) []string {
+	safe := make([]string, len(row))
+	for i, v := range row {
+		safe[i] = spreadsheetSafe(v)
+	}
+	return safe
+}
+
+// GradebookHandler 导出成绩册，format 支持 json（默认）、csv、xlsx
+func GradebookHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	header, rows := buildGradebook(course)
+	filename := fmt.Sprintf("gradebook_course_%d", course.ID)
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(200, gin.H{"header": header, "rows": rows})
+	case "csv":
+		var buf bytes.Buffer
+		buf.WriteString("\xEF\xBB\xBF") // UTF-8 BOM，保证 Excel 直接打开中文不乱码
+		w := csv.NewWriter(&buf)
+		w.Write(spreadsheetSafeRow(header))
+		for _, row := range rows {
+			w.Write(spreadsheetSafeRow(row))
+		}
+		w.Flush()
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+		c.Data(200, "text/csv; charset=utf-8", buf.Bytes())
+	case "xlsx":
+		f := excelize.NewFile()
+		defer f.Close()
+		sheet := f.GetSheetName(0)
+		f.SetSheetRow(sheet, "A1", &header)
+		for i, row := range rows {
+			cells := make([]interface{}, len(row))
+			for j, v := range row {
+				// 数值列写成数字，方便在表格里直接计算
+				if n, err := strconv.ParseFloat(v, 64); err == nil && j >= 2 {
+					cells[j] = n
+				} else {
+					cells[j] = v
+				}
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, i+2)
+			f.SetSheetRow(sheet, cell, &cells)
+		}
+		buf, err := f.WriteToBuffer()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "导出失败"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		c.Data(200, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+	default:
+		c.JSON(400, gin.H{"error": "不支持的导出格式"})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestGradebookOnlyCountsGradedHomework(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	teacher := createTestUser(t, "teacher", "teacher")
+	course := createTestCourse(t, teacher)
+	assignment := Assignment{CourseID: course.ID, TeacherID: teacher.ID, Title: "作业一", MaxScore: 100}
+	db.Create(&assignment)
+
+	tests := []struct {
+		status string
+		score  int
+		want   string
+	}{
+		{HomeworkGraded, 90, "90"},
+		{HomeworkReturned, 40, ""},
+		{HomeworkSubmitted, 0, ""},
+		{HomeworkResubmitted, 60, ""},
+	}
+	for i, tt := range tests {
+		student := createTestUser(t, fmt.Sprintf("student%d", i), "student")
+		db.Create(&Enrollment{UserID: student.ID, CourseID: course.ID})
+		db.Create(&Homework{CourseID: course.ID, AssignmentID: assignment.ID, StudentID: student.ID,
+			Content: "答案", Score: tt.score, Status: tt.status})
+	}
+
+	w := doRequest(r, http.MethodGet, fmt.Sprintf("/api/v1/courses/%d/gradebook", course.ID), loginAs(t, teacher), nil)
+	if w.Code != 200 {
+		t.Fatalf("状态码 = %d，响应 %s", w.Code, w.Body.String())
+	}
+	rows := decodeBody(t, w)["rows"].([]interface{})
+	if len(rows) != len(tests) {
+		t.Fatalf("成绩册有 %d 行，期望 %d 行", len(rows), len(tests))
+	}
+	for i, tt := range tests {
+		// 每行依次为 学生ID、用户名、各作业成绩
+		if got := rows[i].([]interface{})[2]; got != tt.want {
+			t.Errorf("%s 状态的作业成绩 = %q，期望 %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestSpreadsheetSafe(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1+1", "'\t=1+1"},
+		{"\r=1+1", "'\r=1+1"},
+		{"张三", "张三"},
+		{"90", "90"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := spreadsheetSafe(tt.in); got != tt.want {
+			t.Errorf("spreadsheetSafe(%q) = %q，期望 %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGradebookExportEscaping(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	teacher := createTestUser(t, "teacher", "teacher")
+	token := loginAs(t, teacher)
+	course := createTestCourse(t, teacher)
+	db.Create(&Assignment{CourseID: course.ID, TeacherID: teacher.ID, Title: "=作业", MaxScore: 100})
+	student := createTestUser(t, "=evil", "student")
+	db.Create(&Enrollment{UserID: student.ID, CourseID: course.ID})
+	path := fmt.Sprintf("/api/v1/courses/%d/gradebook?format=", course.ID)
+
+	// CSV 会被表格软件按公式解析，需要转义
+	w := doRequest(r, http.MethodGet, path+"csv", token, nil)
+	if body := w.Body.String(); !strings.Contains(body, "'=evil") || !strings.Contains(body, "'=作业") {
+		t.Fatalf("CSV 单元格没有转义: %s", body)
+	}
+
+	// XLSX 的字符串单元格原样保存
+	w = doRequest(r, http.MethodGet, path+"xlsx", token, nil)
+	f, err := excelize.OpenReader(w.Body)
+	if err != nil {
+		t.Fatalf("XLSX 无法打开: %v", err)
+	}
+	defer f.Close()
+	rows, _ := f.GetRows(f.GetSheetName(0))
+	if len(rows) != 2 || !strings.HasPrefix(rows[0][2], "=作业") || rows[1][1] != "=evil" {
+		t.Fatalf("XLSX 单元格内容不正确: %v", rows)
+	}
+}
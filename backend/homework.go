@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ===========================
+// 作业提交历史与附件
+// ===========================
+
+// HomeworkRevision 每次提交生成一条不可变的版本记录
+type HomeworkRevision struct {
+	gorm.Model
+	HomeworkID  uint                 `gorm:"index" json:"homework_id"`
+	Version     int                  `json:"version"`
+	Content     string               `json:"content" gorm:"type:text"`
+	IsLate      bool                 `json:"is_late"`
+	Attachments []HomeworkAttachment `gorm:"many2many:homework_revision_attachments" json:"attachments"`
+}
+
+// HomeworkAttachment 存放在私有 homework 桶中的附件，先上传再随提交关联到作业
+type HomeworkAttachment struct {
+	gorm.Model
+	UploaderID  uint   `gorm:"index" json:"uploader_id"`
+	HomeworkID  uint   `gorm:"index" json:"homework_id"` // 0 表示尚未随提交关联
+	ObjectName  string `json:"-"`
+	FileName    string `json:"file_name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+type DiffLine struct {
+	Op   string `json:"op"` // equal / insert / delete
+	Text string `json:"text"`
+}
+
+const (
+	maxAttachmentSize  = 50 << 20 // 单个附件上限 50MB
+	maxAttachmentCount = 10
+	attachmentURLTTL   = 10 * time.Minute
+)
+
+var errBadAttachment = errors.New("附件无效或不属于当前用户")
+
+// 超过该规模的 diff 不做逐行对齐，直接整体替换，避免 O(n*m) 占用过多内存
+const maxDiffCells = 4_000_000
+
+// saveSubmission 更新学生在某作业下的提交并追加一条版本记录
+func saveSubmission(tx *gorm.DB, assignment Assignment, studentID uint, content string, isLate bool, attachmentIDs []uint) (Homework, error) {
+	var hw Homework
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("assignment_id = ? AND student_id = ?", assignment.ID, studentID).First(&hw).Error
+	switch {
+	case err == nil:
+		status := hw.Status
+		if status == HomeworkGraded || status == HomeworkReturned {
+			status = HomeworkResubmitted
+		}
+		hw.Revision++
+		err = tx.Model(&hw).Updates(map[string]interface{}{
+			"content":  content,
+			"is_late":  isLate,
+			"status":   status,
+			"revision": hw.Revision,
+		}).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		hw = Homework{
+			CourseID:     assignment.CourseID,
+			AssignmentID: assignment.ID,
+			StudentID:    studentID,
+			Content:      content,
+			IsLate:       isLate,
+			Status:       HomeworkSubmitted,
+			Revision:     1,
+		}
+		err = tx.Create(&hw).Error
+	}
+	if err != nil {
+		return hw, err
+	}
+
+	var attachments []HomeworkAttachment
+	if len(attachmentIDs) > 0 {
+		if len(attachmentIDs) > maxAttachmentCount {
+			return hw, errBadAttachment
+		}
+		// 只能引用自己上传的、未关联或已关联到本作业的附件
+		tx.Where("id IN ? AND uploader_id = ? AND homework_id IN ?", attachmentIDs, studentID, []uint{0, hw.ID}).
+			Find(&attachments)
+		if len(attachments) != len(attachmentIDs) {
+			return hw, errBadAttachment
+		}
+		if err := tx.Model(&HomeworkAttachment{}).Where("id IN ?", attachmentIDs).
+			Update("homework_id", hw.ID).Error; err != nil {
+			return hw, err
+		}
+	}
+	rev := HomeworkRevision{HomeworkID: hw.ID, Version: hw.Revision, Content: content, IsLate: isLate, Attachments: attachments}
+	return hw, tx.Omit("Attachments.*").Create(&rev).Error
+}
+
+// currentAttachments 返回作业最新版本引用的附件
+func currentAttachments(hw Homework) []HomeworkAttachment {
+	var rev HomeworkRevision
+	if err := db.Preload("Attachments").Where("homework_id = ? AND version = ?", hw.ID, hw.Revision).First(&rev).Error; err != nil {
+		return []HomeworkAttachment{}
+	}
+	return rev.Attachments
+}
+
+// migrateHomeworkRevisions 为引入版本记录之前的提交补一条初始版本
+func migrateHomeworkRevisions() {
+	var homeworks []Homework
+	db.Where("revision = 0").Find(&homeworks)
+	for _, hw := range homeworks {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			rev := HomeworkRevision{HomeworkID: hw.ID, Version: 1, Content: hw.Content, IsLate: hw.IsLate}
+			rev.CreatedAt = hw.UpdatedAt
+			if err := tx.Create(&rev).Error; err != nil {
+				return err
+			}
+			return tx.Model(&hw).UpdateColumn("revision", 1).Error
+		})
+		if err != nil {
+			log.Printf("⚠️ 作业 %d 版本迁移失败: %v", hw.ID, err)
+		}
+	}
+}
+
+// loadVisibleHomework 加载作业，仅提交者本人、授课教师和管理员可见，失败时已写入响应
+func loadVisibleHomework(c *gin.Context) (Homework, bool) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	return loadHomeworkByID(c, uint(id))
+}
+
+func loadHomeworkByID(c *gin.Context, id uint) (Homework, bool) {
+	var hw Homework
+	if err := db.First(&hw, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "作业不存在"})
+		return hw, false
+	}
+	userID := c.MustGet("userID").(uint)
+	if hw.StudentID == userID || can(c, PermCourseManageAny) {
+		return hw, true
+	}
+	var course Course
+	if db.Select("id", "teacher_id").First(&course, hw.CourseID).Error == nil && course.TeacherID == userID {
+		return hw, true
+	}
+	c.JSON(403, gin.H{"error": "权限不足"})
+	return hw, false
+}
+
+func UploadHomeworkAttachmentHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "No file"})
+		return
+	}
+	if file.Size > maxAttachmentSize {
+		c.JSON(400, gin.H{"error": "附件不能超过50MB"})
+		return
+	}
+	objectName, err := putUploadedFile(BUCKET_HOMEWORK, file)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "上传失败"})
+		return
+	}
+	attachment := HomeworkAttachment{
+		UploaderID:  userID,
+		ObjectName:  objectName,
+		FileName:    file.Filename,
+		Size:        file.Size,
+		ContentType: contentTypeOf(file.Filename),
+	}
+	db.Create(&attachment)
+	c.JSON(200, gin.H{"data": attachment})
+}
+
+// HomeworkAttachmentURLHandler 为附件签发短期有效的下载地址
+func HomeworkAttachmentURLHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var attachment HomeworkAttachment
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.First(&attachment, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "附件不存在"})
+		return
+	}
+	// 未关联的附件只有上传者本人可以查看；已关联的按作业可见性判断
+	if attachment.HomeworkID == 0 {
+		if attachment.UploaderID != userID {
+			c.JSON(403, gin.H{"error": "权限不足"})
+			return
+		}
+	} else if _, ok := loadHomeworkByID(c, attachment.HomeworkID); !ok {
+		return
+	}
+
+	params := url.Values{}
+	params.Set("response-content-disposition", "attachment; filename*=UTF-8''"+url.PathEscape(attachment.FileName))
+	u, err := minioPresignClient.PresignedGetObject(context.Background(), BUCKET_HOMEWORK, attachment.ObjectName, attachmentURLTTL, params)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "生成下载地址失败"})
+		return
+	}
+	c.JSON(200, gin.H{"url": u.String(), "expires_in": int(attachmentURLTTL.Seconds())})
+}
+
+func ListHomeworkRevisionsHandler(c *gin.Context) {
+	hw, ok := loadVisibleHomework(c)
+	if !ok {
+		return
+	}
+	var revisions []HomeworkRevision
+	db.Preload("Attachments").Where("homework_id = ?", hw.ID).Order("version asc").Find(&revisions)
+	c.JSON(200, gin.H{"data": revisions, "graded_revision": hw.GradedRevision})
+}
+
+// DiffHomeworkRevisionsHandler 对比两个版本，默认对比上次批改的版本（或上一版）与最新版
+func DiffHomeworkRevisionsHandler(c *gin.Context) {
+	hw, ok := loadVisibleHomework(c)
+	if !ok {
+		return
+	}
+	to, err := strconv.Atoi(c.DefaultQuery("to", strconv.Itoa(hw.Revision)))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	defaultFrom := hw.GradedRevision
+	if defaultFrom == 0 || defaultFrom >= to {
+		defaultFrom = to - 1
+	}
+	from, err := strconv.Atoi(c.DefaultQuery("from", strconv.Itoa(defaultFrom)))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+
+	var revisions []HomeworkRevision
+	db.Where("homework_id = ? AND version IN ?", hw.ID, []int{from, to}).Find(&revisions)
+	var fromText, toText string
+	found := 0
+	for _, r := range revisions {
+		if r.Version == from {
+			fromText = r.Content
+			found++
+		}
+		if r.Version == to {
+			toText = r.Content
+			found++
+		}
+	}
+	// from 为 0 表示与空内容对比（第一次提交）
+	if from == 0 {
+		found++
+	}
+	if found < 2 {
+		c.JSON(404, gin.H{"error": "版本不存在"})
+		return
+	}
+	c.JSON(200, gin.H{"from": from, "to": to, "lines": diffLines(fromText, toText)})
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
+
+// diffLines 基于最长公共子序列计算逐行差异
+func diffLines(a, b string) []DiffLine {
+	x, y := splitLines(a), splitLines(b)
+	n, m := len(x), len(y)
+	if n*m > maxDiffCells {
+		out := make([]DiffLine, 0, n+m)
+		for _, line := range x {
+			out = append(out, DiffLine{Op: "delete", Text: line})
+		}
+		for _, line := range y {
+			out = append(out, DiffLine{Op: "insert", Text: line})
+		}
+		return out
+	}
+
+	// lcs[i][j] 为 x[i:] 与 y[j:] 的最长公共子序列长度
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	out := make([]DiffLine, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, DiffLine{Op: "equal", Text: x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, DiffLine{Op: "delete", Text: x[i]})
+			i++
+		default:
+			out = append(out, DiffLine{Op: "insert", Text: y[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		out = append(out, DiffLine{Op: "delete", Text: x[i]})
+	}
+	for ; j < m; j++ {
+		out = append(out, DiffLine{Op: "insert", Text: y[j]})
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 管理员模拟登录
+// ===========================
+
+// 模拟登录令牌以目标用户身份访问，act 声明记录实际操作的管理员（参考 RFC 8693）：
+//
+//	{"user_id": 目标用户, "act": {"sub": 管理员ID, "gid": 授权记录ID}}
+//
+// 令牌不属于任何会话，也不能续期，过期或被结束后需要重新申请
+const defaultImpersonationTTL = 15 * time.Minute
+
+// Impersonation 一次模拟登录授权，结束或过期后令牌立即失效
+type Impersonation struct {
+	gorm.Model
+	ActorID    uint       `gorm:"index" json:"actor_id"`
+	Actor      User       `gorm:"foreignKey:ActorID" json:"actor"`
+	UserID     uint       `gorm:"index" json:"user_id"`
+	User       User       `gorm:"foreignKey:UserID" json:"user"`
+	Reason     string     `json:"reason"`
+	AllowWrite bool       `json:"allow_write"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	EndedAt    *time.Time `json:"ended_at"`
+}
+
+func (i Impersonation) active(now time.Time) bool {
+	return i.EndedAt == nil && now.Before(i.ExpiresAt)
+}
+
+// ImpersonationRequest 模拟登录期间的每一次请求，包括被拦截的请求
+type ImpersonationRequest struct {
+	ID              uint      `gorm:"primarykey" json:"id"`
+	ImpersonationID uint      `gorm:"index" json:"impersonation_id"`
+	Method          string    `json:"method"`
+	Path            string    `json:"path"`
+	Status          int       `json:"status"`
+	IP              string    `json:"ip"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// impersonationForbidden 账号安全相关接口在模拟登录下始终不可用，其余写操作取决于授权
+func impersonationForbidden(method, path string, allowWrite bool) bool {
+	if strings.HasPrefix(path, "/api/v1/admin/") || strings.HasPrefix(path, "/api/v1/user/data-export") {
+		return true
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return false
+	}
+	if !allowWrite || strings.HasPrefix(path, "/api/v1/user/") || path == "/api/v1/logout" {
+		return true
+	}
+	return false
+}
+
+// authenticateImpersonation AuthMiddleware 中处理带 act 声明的令牌，失败时已中止请求
+func authenticateImpersonation(c *gin.Context, userID uint, tokenVer int, act map[string]interface{}) bool {
+	gid, _ := act["gid"].(float64)
+	actorID, _ := act["sub"].(float64)
+	var imp Impersonation
+	if err := db.First(&imp, uint(gid)).Error; err != nil || !imp.active(time.Now()) ||
+		imp.UserID != userID || imp.ActorID != uint(actorID) {
+		c.AbortWithStatusJSON(401, gin.H{"error": "模拟登录已结束"})
+		return false
+	}
+	// 管理员被禁用或失去权限后，已签发的模拟令牌同样失效
+	var actor User
+	if err := db.Select("role", "disabled_at").First(&actor, imp.ActorID).Error; err != nil ||
+		actor.disabled() || !hasPermission(actor.Role, PermUserImpersonate) {
+		c.AbortWithStatusJSON(401, gin.H{"error": "模拟登录已结束"})
+		return false
+	}
+	var user User
+	if err := db.Select("token_version", "role", "disabled_at").First(&user, userID).Error; err != nil ||
+		user.TokenVersion != tokenVer || user.disabled() {
+		c.AbortWithStatusJSON(401, gin.H{"error": "模拟登录已结束"})
+		return false
+	}
+
+	c.Set("userID", userID)
+	c.Set("sessionID", uint(0))
+	c.Set("role", user.Role)
+	c.Set("actorID", imp.ActorID)
+	c.Set("impersonationID", imp.ID)
+	if impersonationForbidden(c.Request.Method, c.FullPath(), imp.AllowWrite) {
+		c.AbortWithStatusJSON(403, gin.H{"error": "模拟登录状态下不允许该操作"})
+		recordImpersonatedRequest(c)
+		return false
+	}
+	return true
+}
+
+// recordImpersonatedRequest 在请求处理完后记录，保存实际的响应状态码
+func recordImpersonatedRequest(c *gin.Context) {
+	db.Create(&ImpersonationRequest{
+		ImpersonationID: c.MustGet("impersonationID").(uint),
+		Method:          c.Request.Method,
+		Path:            c.Request.URL.Path,
+		Status:          c.Writer.Status(),
+		IP:              c.ClientIP(),
+	})
+}
+
+// StartImpersonationHandler 签发以目标用户身份访问的临时令牌，必须填写原因
+func StartImpersonationHandler(c *gin.Context) {
+	target, ok := loadTargetUser(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason     string `json:"reason"`
+		Minutes    int    `json:"minutes"`
+		AllowWrite bool   `json:"allow_write"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.JSON(400, gin.H{"error": "请填写模拟登录原因"})
+		return
+	}
+	if target.disabled() {
+		c.JSON(400, gin.H{"error": "该账号已被禁用"})
+		return
+	}
+	// 不允许模拟同样拥有管理权限的账号，避免借此绕过权限边界
+	if hasPermission(target.Role, PermUserManage) || hasPermission(target.Role, PermUserImpersonate) {
+		c.JSON(403, gin.H{"error": "不能模拟管理员账号"})
+		return
+	}
+	if req.AllowWrite && !IMPERSONATION_ALLOW_WRITE {
+		c.JSON(400, gin.H{"error": "系统未开启模拟登录写操作"})
+		return
+	}
+	ttl := defaultImpersonationTTL
+	if req.Minutes > 0 {
+		ttl = time.Duration(req.Minutes) * time.Minute
+	}
+	ttl = min(ttl, IMPERSONATION_MAX_TTL)
+
+	imp := Impersonation{
+		ActorID:    c.MustGet("userID").(uint),
+		UserID:     target.ID,
+		Reason:     strings.TrimSpace(req.Reason),
+		AllowWrite: req.AllowWrite,
+		ExpiresAt:  time.Now().Add(ttl),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&imp).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditImpersonate, "user", target.ID, nil, gin.H{
+			"impersonation_id": imp.ID,
+			"reason":           imp.Reason,
+			"allow_write":      imp.AllowWrite,
+			"expires_at":       imp.ExpiresAt,
+		})
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "创建失败"})
+		return
+	}
+	token, err := signJWT(jwt.MapClaims{
+		"user_id": target.ID,
+		"role":    target.Role,
+		"version": target.TokenVersion,
+		"act":     map[string]interface{}{"sub": imp.ActorID, "gid": imp.ID},
+		"exp":     imp.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "创建失败"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"token":            token,
+		"expires_in":       int(ttl.Seconds()),
+		"impersonation_id": imp.ID,
+		"username":         target.Username,
+		"role":             target.Role,
+		"user_id":          target.ID,
+		"allow_write":      imp.AllowWrite,
+	})
+}
+
+// ListImpersonationsHandler 分页查询模拟登录记录，可按管理员或目标用户筛选
+func ListImpersonationsHandler(c *gin.Context) {
+	page, pageSize := pageParams(c)
+	tx := db.Model(&Impersonation{})
+	if v := c.Query("actor_id"); v != "" {
+		tx = tx.Where("actor_id = ?", v)
+	}
+	if v := c.Query("user_id"); v != "" {
+		tx = tx.Where("user_id = ?", v)
+	}
+	var total int64
+	tx.Count(&total)
+	var list []Impersonation
+	tx.Preload("Actor").Preload("User").Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list)
+	c.JSON(200, gin.H{"data": list, "total": total, "page": page, "page_size": pageSize})
+}
+
+// GetImpersonationHandler 返回一次模拟登录及期间的全部请求
+func GetImpersonationHandler(c *gin.Context) {
+	var imp Impersonation
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.Preload("Actor").Preload("User").First(&imp, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "记录不存在"})
+		return
+	}
+	var requests []ImpersonationRequest
+	db.Where("impersonation_id = ?", imp.ID).Order("id").Find(&requests)
+	c.JSON(200, gin.H{"data": imp, "requests": requests})
+}
+
+// EndImpersonationHandler 提前结束模拟登录，令牌立即失效
+func EndImpersonationHandler(c *gin.Context) {
+	res := db.Model(&Impersonation{}).Where("id = ? AND ended_at IS NULL", c.Param("id")).Update("ended_at", time.Now())
+	if res.Error != nil || res.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "模拟登录不存在或已结束"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "已结束模拟登录"})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// impersonationToken 按 StartImpersonationHandler 的方式签发带 act 声明的令牌
+func impersonationToken(t *testing.T, actor, target User, allowWrite bool) string {
+	t.Helper()
+	imp := Impersonation{ActorID: actor.ID, UserID: target.ID, Reason: "排查问题", AllowWrite: allowWrite,
+		ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&imp).Error; err != nil {
+		t.Fatalf("创建模拟登录失败: %v", err)
+	}
+	token, err := signJWT(jwt.MapClaims{
+		"user_id": target.ID,
+		"role":    target.Role,
+		"version": target.TokenVersion,
+		"act":     map[string]interface{}{"sub": actor.ID, "gid": imp.ID},
+		"exp":     imp.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+	return token
+}
+
+func TestImpersonationAuth(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	admin := createTestUser(t, "admin", "admin")
+	formerAdmin := createTestUser(t, "former_admin", "teacher")
+	student := createTestUser(t, "student", "student")
+	course := createTestCourse(t, admin)
+
+	tests := []struct {
+		name       string
+		actor      User
+		allowWrite bool
+		method     string
+		path       string
+		want       int
+	}{
+		{"只读访问", admin, false, http.MethodGet, "/api/v1/my-courses", 200},
+		{"未授权写操作", admin, false, http.MethodPost, "/api/v1/enroll", 403},
+		{"授权写操作", admin, true, http.MethodPost, "/api/v1/enroll", 200},
+		{"不能访问管理接口", admin, true, http.MethodGet, "/api/v1/admin/users", 403},
+		{"不能修改账号安全设置", admin, true, http.MethodPut, "/api/v1/user/profile", 403},
+		{"管理员已失去权限", formerAdmin, false, http.MethodGet, "/api/v1/my-courses", 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := impersonationToken(t, tt.actor, student, tt.allowWrite)
+			var body interface{}
+			if tt.method != http.MethodGet {
+				body = map[string]interface{}{"course_id": course.ID}
+			}
+			w := doRequest(r, tt.method, tt.path, token, body)
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestImpersonatedRequestsAreRecorded(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	admin := createTestUser(t, "admin", "admin")
+	student := createTestUser(t, "student", "student")
+	token := impersonationToken(t, admin, student, false)
+
+	doRequest(r, http.MethodGet, "/api/v1/my-courses", token, nil)
+	doRequest(r, http.MethodPost, "/api/v1/enroll", token, map[string]interface{}{"course_id": 1})
+
+	var records []ImpersonationRequest
+	db.Order("id asc").Find(&records)
+	if len(records) != 2 {
+		t.Fatalf("记录了 %d 条请求，期望 2 条", len(records))
+	}
+	if records[0].Status != 200 || records[1].Status != 403 {
+		t.Fatalf("记录的状态码 = %d, %d，期望 200, 403", records[0].Status, records[1].Status)
+	}
+}
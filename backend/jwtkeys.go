@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ===========================
+// JWT 签名密钥与 JWKS
+// ===========================
+
+// 密钥目录中每个文件是一把密钥，文件名（去掉扩展名）即 kid：
+//   - <kid>.pem      PKCS#8 私钥（RSA 或 Ed25519），可用于签名和校验
+//   - <kid>.pub.pem  PKIX 公钥，只用于校验，轮换后保留旧公钥直到旧令牌全部过期
+//
+// JWT_SIGNING_KID 指定当前签名用的 kid，不指定时取目录中按名称排序的最后一把私钥。
+// 也可以直接通过 JWT_PRIVATE_KEY（PEM 内容）和 JWT_KID 注入单把密钥
+var (
+	JWT_KEYS_DIR    = "keys"
+	JWT_SIGNING_KID = ""
+)
+
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer // 只有公钥时为 nil
+	public  crypto.PublicKey
+}
+
+var jwtKeys = struct {
+	signing *jwtKey
+	byKID   map[string]*jwtKey
+}{byKID: map[string]*jwtKey{}}
+
+var errTokenInvalid = errors.New("Token无效")
+
+// initJWTKeys 加载签名密钥，目录中没有任何私钥时生成一把 Ed25519 密钥，方便本地开发
+func initJWTKeys() {
+	if v := os.Getenv("JWT_KEYS_DIR"); v != "" {
+		JWT_KEYS_DIR = v
+	}
+	JWT_SIGNING_KID = os.Getenv("JWT_SIGNING_KID")
+
+	if pemData := os.Getenv("JWT_PRIVATE_KEY"); pemData != "" {
+		kid := os.Getenv("JWT_KID")
+		if kid == "" {
+			kid = "env"
+		}
+		if err := addJWTKey(kid, []byte(pemData)); err != nil {
+			log.Fatalf("❌ JWT_PRIVATE_KEY 解析失败: %v", err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(JWT_KEYS_DIR, "*.pem"))
+	for _, f := range files {
+		kid := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(f), ".pem"), ".pub")
+		if _, ok := jwtKeys.byKID[kid]; ok {
+			continue
+		}
+		data, err := os.ReadFile(f)
+		if err == nil {
+			err = addJWTKey(kid, data)
+		}
+		if err != nil {
+			log.Fatalf("❌ 读取 JWT 密钥 %s 失败: %v", f, err)
+		}
+	}
+
+	if JWT_SIGNING_KID == "" {
+		var kids []string
+		for kid, k := range jwtKeys.byKID {
+			if k.private != nil {
+				kids = append(kids, kid)
+			}
+		}
+		sort.Strings(kids)
+		if len(kids) > 0 {
+			JWT_SIGNING_KID = kids[len(kids)-1]
+		}
+	}
+	if JWT_SIGNING_KID == "" {
+		JWT_SIGNING_KID = generateJWTKey()
+	}
+	k, ok := jwtKeys.byKID[JWT_SIGNING_KID]
+	if !ok || k.private == nil {
+		log.Fatalf("❌ 找不到 kid 为 %s 的签名私钥", JWT_SIGNING_KID)
+	}
+	jwtKeys.signing = k
+	log.Printf("🔑 JWT 使用 %s 签名（kid=%s），共 %d 把校验密钥", k.method.Alg(), k.kid, len(jwtKeys.byKID))
+}
+
+func addJWTKey(kid string, data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("不是有效的 PEM")
+	}
+	k := &jwtKey{kid: kid}
+	switch block.Type {
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+		var key interface{}
+		var err error
+		if block.Type == "RSA PRIVATE KEY" {
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return errors.New("不支持的私钥类型")
+		}
+		k.private, k.public = signer, signer.Public()
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		k.public = key
+	default:
+		return fmt.Errorf("不支持的 PEM 类型 %s", block.Type)
+	}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return errors.New("RSA 密钥至少 2048 位")
+		}
+		k.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return errors.New("只支持 RSA 和 Ed25519 密钥")
+	}
+	jwtKeys.byKID[kid] = k
+	return nil
+}
+
+// generateJWTKey 生成一把 Ed25519 密钥并尽量写入密钥目录，保证重启后已签发的令牌仍然有效
+func generateJWTKey() string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("❌ 生成 JWT 密钥失败: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	kid := "dev-" + randomString()[:8]
+	if err := os.MkdirAll(JWT_KEYS_DIR, 0o700); err == nil {
+		err = os.WriteFile(filepath.Join(JWT_KEYS_DIR, kid+".pem"), data, 0o600)
+		if err != nil {
+			log.Printf("⚠️ JWT 密钥写入失败，重启后需要重新登录: %v", err)
+		}
+	}
+	log.Printf("⚠️ 未配置 JWT 签名密钥，已生成 %s/%s.pem，生产环境请自行配置", JWT_KEYS_DIR, kid)
+	addJWTKey(kid, data)
+	return kid
+}
+
+// signJWT 使用当前签名密钥签发令牌，header 中带上 kid
+func signJWT(claims jwt.MapClaims) (string, error) {
+	k := jwtKeys.signing
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	return token.SignedString(k.private)
+}
+
+// parseJWT 按 kid 选择校验密钥，签名算法必须与密钥类型一致
+func parseJWT(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		k, ok := jwtKeys.byKID[kid]
+		if !ok || token.Method.Alg() != k.method.Alg() {
+			return nil, errTokenInvalid
+		}
+		return k.public, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil || !token.Valid {
+		return nil, errTokenInvalid
+	}
+	return token.Claims.(jwt.MapClaims), nil
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// JWKSHandler 公开所有校验公钥，供其他内部服务校验本平台签发的令牌
+func JWKSHandler(c *gin.Context) {
+	keys := make([]gin.H, 0, len(jwtKeys.byKID))
+	for _, k := range jwtKeys.byKID {
+		jwk := gin.H{"kid": k.kid, "use": "sig", "alg": k.method.Alg()}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = b64url(pub.N.Bytes())
+			jwk["e"] = b64url(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = b64url(pub)
+		}
+		keys = append(keys, jwk)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i]["kid"].(string) < keys[j]["kid"].(string) })
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, gin.H{"keys": keys})
+}
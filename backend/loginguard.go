@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 登录防暴力破解
+// ===========================
+
+var (
+	// 统计窗口内同一用户名连续失败达到上限后锁定一段时间
+	LOGIN_MAX_FAILURES  = 5
+	LOGIN_LOCK_DURATION = 15 * time.Minute
+	// 同一 IP 在统计窗口内失败次数上限，防止换用户名撞库
+	LOGIN_IP_MAX_FAILURES = 30
+	LOGIN_FAILURE_WINDOW  = 15 * time.Minute
+	// 从第几次失败开始延迟响应，之后每次翻倍，最长 loginMaxDelay
+	loginDelayAfter = 2
+	loginMaxDelay   = 8 * time.Second
+)
+
+// 用户不存在时也做一次哈希比较，使两种失败的耗时一致
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// counterStore 带过期时间的计数器，优先使用 Redis，多实例部署时共享状态
+type counterStore interface {
+	// Incr 计数加一，计数器第一次创建时设置过期时间
+	Incr(key string, ttl time.Duration) (int64, error)
+	// Set 写入一个到期自动删除的标记
+	Set(key string, ttl time.Duration) error
+	// Count 返回当前计数，不存在时为 0
+	Count(key string) int64
+	// TTL 返回剩余有效期，不存在时为 0
+	TTL(key string) time.Duration
+	Del(keys ...string)
+}
+
+type redisStore struct {
+	client *redis.Client
+}
+
+// incrScript 在一条命令内完成计数和设置过期时间，避免进程在两步之间退出留下永不过期的计数
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+func (s redisStore) Incr(key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(context.Background(), s.client, []string{key}, ttl.Milliseconds()).Int64()
+}
+
+func (s redisStore) Set(key string, ttl time.Duration) error {
+	return s.client.Set(context.Background(), key, 1, ttl).Err()
+}
+
+func (s redisStore) Count(key string) int64 {
+	n, _ := s.client.Get(context.Background(), key).Int64()
+	return n
+}
+
+func (s redisStore) TTL(key string) time.Duration {
+	d, err := s.client.PTTL(context.Background(), key).Result()
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+func (s redisStore) Del(keys ...string) {
+	s.client.Del(context.Background(), keys...)
+}
+
+// memoryStore Redis 不可用时的单机实现
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	count     int64
+	expiresAt time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{entries: map[string]memoryEntry{}}
+	go func() {
+		for range time.Tick(time.Minute) {
+			s.mu.Lock()
+			now := time.Now()
+			for k, e := range s.entries {
+				if now.After(e.expiresAt) {
+					delete(s.entries, k)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return s
+}
+
+func (s *memoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	e, ok := s.entries[key]
+	if !ok || now.After(e.expiresAt) {
+		e = memoryEntry{expiresAt: now.Add(ttl)}
+	}
+	e.count++
+	s.entries[key] = e
+	return e.count, nil
+}
+
+func (s *memoryStore) Set(key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryEntry{count: 1, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryStore) Count(key string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		return 0
+	}
+	return e.count
+}
+
+func (s *memoryStore) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return 0
+	}
+	return max(time.Until(e.expiresAt), 0)
+}
+
+func (s *memoryStore) Del(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		delete(s.entries, k)
+	}
+}
+
+var loginStore counterStore
+
+// initLoginGuard 读取限制配置并连接 Redis，连接失败时退回内存计数
+func initLoginGuard() {
+	if v, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILURES")); err == nil && v > 0 {
+		LOGIN_MAX_FAILURES = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("LOGIN_IP_MAX_FAILURES")); err == nil && v > 0 {
+		LOGIN_IP_MAX_FAILURES = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LOGIN_LOCK_DURATION")); err == nil && v > 0 {
+		LOGIN_LOCK_DURATION = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LOGIN_FAILURE_WINDOW")); err == nil && v > 0 {
+		LOGIN_FAILURE_WINDOW = v
+	}
+
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		log.Printf("⚠️ 未配置 REDIS_HOST，登录失败计数仅保存在本机内存")
+		loginStore = newMemoryStore()
+		return
+	}
+	port := os.Getenv("REDIS_PORT")
+	if port == "" {
+		port = "6379"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     host + ":" + port,
+		Password: os.Getenv("REDIS_PASSWORD"),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Printf("⚠️ Redis 连接失败，登录失败计数仅保存在本机内存: %v", err)
+		loginStore = newMemoryStore()
+		return
+	}
+	loginStore = redisStore{client: client}
+}
+
+func loginUserKey(username string) string {
+	return "login:fail:user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func loginLockKey(username string) string {
+	return "login:lock:user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func loginIPKey(ip string) string {
+	return "login:fail:ip:" + ip
+}
+
+// loginBlocked 返回用户名或 IP 仍需等待的时间，为 0 表示可以尝试登录
+func loginBlocked(username, ip string) time.Duration {
+	wait := loginStore.TTL(loginLockKey(username))
+	if loginStore.Count(loginIPKey(ip)) >= int64(LOGIN_IP_MAX_FAILURES) {
+		wait = max(wait, loginStore.TTL(loginIPKey(ip)))
+	}
+	return wait
+}
+
+// recordLoginFailure 记录一次失败，达到上限时锁定账号；失败次数越多响应越慢
+func recordLoginFailure(username, ip string) {
+	loginStore.Incr(loginIPKey(ip), LOGIN_FAILURE_WINDOW)
+	n, err := loginStore.Incr(loginUserKey(username), LOGIN_FAILURE_WINDOW)
+	if err != nil {
+		return
+	}
+	if n >= int64(LOGIN_MAX_FAILURES) {
+		loginStore.Set(loginLockKey(username), LOGIN_LOCK_DURATION)
+		loginStore.Del(loginUserKey(username))
+		return
+	}
+	if n > int64(loginDelayAfter) {
+		delay := time.Duration(math.Pow(2, float64(n-int64(loginDelayAfter)-1))) * time.Second
+		time.Sleep(min(delay, loginMaxDelay))
+	}
+}
+
+// recordLoginSuccess 登录成功后清空该用户名的失败计数，IP 计数保留到窗口结束
+func recordLoginSuccess(username string) {
+	loginStore.Del(loginUserKey(username))
+}
+
+func loginBlockedResponse(c *gin.Context, wait time.Duration) {
+	minutes := int(math.Ceil(wait.Minutes()))
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(429, gin.H{"error": fmt.Sprintf("登录失败次数过多，请 %d 分钟后再试", minutes)})
+}
+
+// AdminUnlockUserHandler 清除账号的锁定状态和失败计数
+func AdminUnlockUserHandler(c *gin.Context) {
+	var user User
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.First(&user, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "用户不存在"})
+		return
+	}
+	before := gin.H{
+		"locked":   loginStore.TTL(loginLockKey(user.Username)) > 0,
+		"failures": loginStore.Count(loginUserKey(user.Username)),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return recordAudit(tx, c, AuditUserUnlock, "user", user.ID, before, nil)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "操作失败"})
+		return
+	}
+	loginStore.Del(loginLockKey(user.Username), loginUserKey(user.Username))
+	c.JSON(200, gin.H{"message": "已解除锁定"})
+}
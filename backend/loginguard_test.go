@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLoginGuard(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) counterStore
+	}{
+		{"memory", func(t *testing.T) counterStore { return newMemoryStore() }},
+		{"redis", func(t *testing.T) counterStore {
+			return redisStore{client: redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})}
+		}},
+	}
+	defer func(prev counterStore, maxFailures int) {
+		loginStore, LOGIN_MAX_FAILURES = prev, maxFailures
+	}(loginStore, LOGIN_MAX_FAILURES)
+	LOGIN_MAX_FAILURES = 3
+
+	login := func(r http.Handler, password string) int {
+		return doRequest(r, http.MethodPost, "/api/v1/login", "",
+			map[string]string{"username": "alice", "password": password}).Code
+	}
+
+	for _, s := range stores {
+		tests := []struct {
+			name     string
+			attempts []string // 依次尝试的密码
+			want     []int
+		}{
+			{"密码正确", []string{testPassword}, []int{200}},
+			{"达到上限后锁定，正确密码也被拒绝", []string{"x", "x", "x", testPassword}, []int{401, 401, 401, 429}},
+			{"成功登录清空失败计数", []string{"x", "x", testPassword, "x", "x", testPassword}, []int{401, 401, 200, 401, 401, 200}},
+		}
+		for _, tt := range tests {
+			t.Run(s.name+"/"+tt.name, func(t *testing.T) {
+				setupTestDB(t)
+				loginStore = s.store(t)
+				r := setupRouter()
+				createTestUser(t, "alice", "student")
+				for i, password := range tt.attempts {
+					if got := login(r, password); got != tt.want[i] {
+						t.Fatalf("第 %d 次登录状态码 = %d，期望 %d", i+1, got, tt.want[i])
+					}
+				}
+			})
+		}
+
+		t.Run(s.name+"/锁定响应带 Retry-After，计数带过期时间", func(t *testing.T) {
+			setupTestDB(t)
+			loginStore = s.store(t)
+			r := setupRouter()
+			createTestUser(t, "alice", "student")
+			login(r, "x")
+			if ttl := loginStore.TTL(loginUserKey("alice")); ttl <= 0 || ttl > LOGIN_FAILURE_WINDOW {
+				t.Fatalf("用户失败计数有效期 = %v，期望在 (0, %v]", ttl, LOGIN_FAILURE_WINDOW)
+			}
+			if ttl := loginStore.TTL(loginIPKey("192.0.2.1")); ttl <= 0 {
+				t.Fatalf("IP 失败计数没有设置过期时间")
+			}
+			login(r, "x")
+			login(r, "x")
+			w := doRequest(r, http.MethodPost, "/api/v1/login", "", map[string]string{"username": "ALICE", "password": testPassword})
+			if w.Code != 429 || w.Header().Get("Retry-After") == "" {
+				t.Fatalf("状态码 = %d，Retry-After = %q，期望 429 且带 Retry-After", w.Code, w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestLoginGuardIPLimit(t *testing.T) {
+	defer func(prev counterStore, maxFailures int) {
+		loginStore, LOGIN_IP_MAX_FAILURES = prev, maxFailures
+	}(loginStore, LOGIN_IP_MAX_FAILURES)
+	loginStore = newMemoryStore()
+	LOGIN_IP_MAX_FAILURES = 3
+
+	// 同一 IP 换用户名尝试也会被限制
+	for _, username := range []string{"a", "b", "c"} {
+		if wait := loginBlocked(username, "198.51.100.7"); wait > 0 {
+			t.Fatalf("未达上限时 %s 被限制", username)
+		}
+		recordLoginFailure(username, "198.51.100.7")
+	}
+	if wait := loginBlocked("d", "198.51.100.7"); wait <= 0 {
+		t.Fatal("达到 IP 上限后仍允许登录")
+	}
+	if wait := loginBlocked("d", "198.51.100.8"); wait > 0 {
+		t.Fatal("其他 IP 不应受影响")
+	}
+}
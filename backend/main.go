@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"mime/multipart"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +22,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ===========================
@@ -34,12 +40,34 @@ var (
 	// 我们稍后从环境变量里读
 	MINIO_PUBLIC_ENDPOINT = "localhost:9000"
 
-	MINIO_ACCESS_KEY = "admin"
-	MINIO_SECRET_KEY = "password123"
-	MINIO_USE_SSL    = false
-	BUCKET_PICTURES  = "pictures"
-	BUCKET_VIDEOS    = "videos"
-	JWT_SECRET       = "my_super_secret_key_2026"
+	MINIO_ACCESS_KEY   = "admin"
+	MINIO_SECRET_KEY   = "password123"
+	MINIO_USE_SSL      = false
+	BUCKET_PICTURES    = "pictures"
+	BUCKET_VIDEOS      = "videos"
+	BUCKET_HOMEWORK    = "homework"    // 私有桶，只通过预签名URL访问
+	BUCKET_CREDENTIALS = "credentials" // 私有桶，存放教师申请的资质材料
+	BUCKET_EXPORTS     = "exports"     // 私有桶，存放个人数据导出压缩包
+
+	// 访问令牌短期有效，过期后用刷新令牌换新；刷新令牌在有效期内每次使用都会顺延
+	ACCESS_TOKEN_TTL  = 15 * time.Minute
+	REFRESH_TOKEN_TTL = 30 * 24 * time.Hour
+	// 各角色允许同时在线的会话数，超出时踢掉最久未活跃的会话
+	SESSION_LIMITS = map[string]int{"student": 5, "teacher": 3, "admin": 2}
+
+	// 邮件中链接指向的前端地址，默认跟随 PUBLIC_HOST
+	APP_BASE_URL = "http://localhost"
+
+	// 必须开启两步验证的角色，例如 MFA_REQUIRED_ROLES="admin,teacher"，默认不强制
+	MFA_REQUIRED_ROLES []string
+
+	// 模拟登录令牌的最长有效期；默认模拟期间只读，IMPERSONATION_ALLOW_WRITE=true 时管理员可以按需放开写操作
+	IMPERSONATION_MAX_TTL     = time.Hour
+	IMPERSONATION_ALLOW_WRITE = false
+
+	// 可信的反向代理地址或网段，例如 TRUSTED_PROXIES="172.16.0.0/12"，
+	// 只有来自这些地址的请求才采用 X-Real-IP / X-Forwarded-For，默认直接使用连接地址
+	TRUSTED_PROXIES []string
 )
 
 // ===========================
@@ -53,7 +81,19 @@ type User struct {
 	Role         string `json:"role"`
 	Avatar       string `json:"avatar"`
 	Bio          string `json:"bio"`
-	TokenVersion int    `json:"-"` // 【新增】Token版本号，用于单点登录互斥
+	TokenVersion int    `json:"-"` // Token版本号，自增后该用户所有已签发的 Token 立即失效
+	// Email 未设置时为 NULL，避免唯一索引冲突
+	Email           *string    `gorm:"size:191;uniqueIndex" json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// 两步验证：密钥在确认绑定前就会写入，以 TOTPEnabled 为准
+	TOTPSecret   string `gorm:"size:64" json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"` // 最近一次使用的时间步，防止验证码重放
+	// 被管理员禁用的账号无法登录，已签发的令牌也立即失效
+	DisabledAt     *time.Time `gorm:"index" json:"disabled_at"`
+	DisabledReason string     `json:"disabled_reason"`
+	// 管理员重置密码后要求用户下次登录先修改密码
+	MustChangePassword bool `json:"must_change_password"`
 }
 
 type Course struct {
@@ -67,10 +107,11 @@ type Course struct {
 	Price       float64    `json:"price"`
 	Category    string     `json:"category"`
 	ViewCount   int        `json:"view_count"`
-	Outline     string     `json:"outline" gorm:"type:text"`
+	Outline     string     `json:"outline" gorm:"type:text"` // 旧版大纲 JSON，仅用于导入章节
 	HomeworkReq string     `json:"homework_req" gorm:"type:text"`
 	Status      int        `json:"status" gorm:"default:0"`
 	Homeworks   []Homework `gorm:"foreignKey:CourseID" json:"homeworks"`
+	Chapters    []Chapter  `gorm:"foreignKey:CourseID" json:"chapters"`
 }
 
 type Question struct {
@@ -94,22 +135,69 @@ type Enrollment struct {
 	Course   Course  `gorm:"foreignKey:CourseID" json:"course"`
 }
 
+// 作业批改状态
+const (
+	HomeworkSubmitted   = "submitted"   // 已提交，待批改
+	HomeworkGraded      = "graded"      // 已批改
+	HomeworkReturned    = "returned"    // 退回修改
+	HomeworkResubmitted = "resubmitted" // 批改或退回后重新提交，待批改
+)
+
 type Homework struct {
 	gorm.Model
-	CourseID  uint   `json:"course_id"`
-	StudentID uint   `json:"student_id"`
-	Content   string `json:"content"`
-	Score     int    `json:"score"`
-	Comment   string `json:"comment"`
+	CourseID     uint       `json:"course_id"`
+	AssignmentID uint       `gorm:"index" json:"assignment_id"`
+	StudentID    uint       `json:"student_id"`
+	Content      string     `json:"content"`
+	Score        int        `json:"score"`
+	Comment      string     `json:"comment"`
+	IsLate       bool       `json:"is_late"`
+	Status       string     `json:"status" gorm:"index;default:submitted"`
+	GradedAt     *time.Time `json:"graded_at"`
+	GraderID     uint       `json:"grader_id"`
+	// Revision 为当前提交的版本号，GradedRevision 为最近一次批改时的版本号
+	Revision       int `json:"revision"`
+	GradedRevision int `json:"graded_revision"`
 }
 
 var db *gorm.DB
 var minioClient *minio.Client
 
+// minioPresignClient 使用外部地址签名，生成的预签名URL可以直接给浏览器访问
+var minioPresignClient *minio.Client
+
 func initConfig() {
 	// 尝试从环境变量读取外部 IP，如果没读到就默认用 localhost
 	if envHost := os.Getenv("PUBLIC_HOST"); envHost != "" {
 		MINIO_PUBLIC_ENDPOINT = envHost + ":9000"
+		APP_BASE_URL = "http://" + envHost
+	}
+	if v := os.Getenv("APP_BASE_URL"); v != "" {
+		APP_BASE_URL = strings.TrimRight(v, "/")
+	}
+	if v, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && v > 0 {
+		ACCESS_TOKEN_TTL = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && v > 0 {
+		REFRESH_TOKEN_TTL = v
+	}
+	// 例如 SESSION_LIMITS="student=5,teacher=3,admin=1"，未列出的角色沿用默认值
+	for role, n := range parseSessionLimits(os.Getenv("SESSION_LIMITS")) {
+		SESSION_LIMITS[role] = n
+	}
+	for _, role := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			MFA_REQUIRED_ROLES = append(MFA_REQUIRED_ROLES, role)
+		}
+	}
+	if v, err := time.ParseDuration(os.Getenv("IMPERSONATION_MAX_TTL")); err == nil && v > 0 {
+		IMPERSONATION_MAX_TTL = v
+	}
+	IMPERSONATION_ALLOW_WRITE = os.Getenv("IMPERSONATION_ALLOW_WRITE") == "true"
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			TRUSTED_PROXIES = append(TRUSTED_PROXIES, proxy)
+		}
 	}
 }
 
@@ -134,29 +222,33 @@ func initDB() {
 	sqlDB.SetConnMaxLifetime(time.Minute * 5) // 5分钟后回收连接
 
 	// 自动迁移
-	db.AutoMigrate(&User{}, &Course{}, &Enrollment{}, &Homework{}, &Question{})
+	autoMigrate(db)
 
 	// 数据修复
 	if !db.Migrator().HasColumn(&User{}, "TokenVersion") {
 		db.Migrator().AddColumn(&User{}, "TokenVersion")
 	}
 	db.Model(&Course{}).Where("status IS NULL").Update("status", 1)
+	migrateOutlines()
+	migrateHomeworkReq()
+	// 旧数据以 score > 0 表示已批改
+	db.Model(&Homework{}).Where("status = ? AND score > 0 AND graded_at IS NULL", HomeworkSubmitted).
+		Update("status", HomeworkGraded)
+	migrateHomeworkRevisions()
+
+	migrateRoles()
+	bootstrapAdmin()
+}
 
-	// 管理员初始化
-	var admin User
-	hashedPwd, _ := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.DefaultCost)
-	err = db.Unscoped().Where("username = ?", "admin").First(&admin).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		adminUser := User{Username: "admin", Password: string(hashedPwd), Role: "admin"}
-		db.Create(&adminUser)
-	} else {
-		if admin.DeletedAt.Valid {
-			db.Unscoped().Model(&admin).Update("deleted_at", nil)
-		}
-		admin.Password = string(hashedPwd)
-		admin.Role = "admin"
-		db.Save(&admin)
-	}
+func autoMigrate(tx *gorm.DB) error {
+	return tx.AutoMigrate(&User{}, &Course{}, &Enrollment{}, &Homework{}, &Question{},
+		&Chapter{}, &Lesson{}, &LessonAttachment{}, &Assignment{},
+		&HomeworkRevision{}, &HomeworkAttachment{},
+		&RubricCriterion{}, &RubricLevel{}, &HomeworkRubricScore{},
+		&BankQuestion{}, &Quiz{}, &QuizAttempt{}, &Session{},
+		&UserToken{}, &RecoveryCode{}, &Permission{}, &Role{},
+		&TeacherApplication{}, &ExternalIdentity{}, &APIKey{},
+		&Impersonation{}, &ImpersonationRequest{}, &AuditEvent{}, &DataExport{})
 }
 
 func initMinIO() {
@@ -168,23 +260,48 @@ func initMinIO() {
 	if err != nil {
 		log.Fatalf("❌ MinIO 连接失败: %v", err)
 	}
+	// 指定 Region 后签名不需要访问外部地址
+	minioPresignClient, err = minio.New(MINIO_PUBLIC_ENDPOINT, &minio.Options{
+		Creds:  credentials.NewStaticV4(MINIO_ACCESS_KEY, MINIO_SECRET_KEY, ""),
+		Secure: MINIO_USE_SSL,
+		Region: "us-east-1",
+	})
+	if err != nil {
+		log.Fatalf("❌ MinIO 签名客户端初始化失败: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, bucket := range []string{BUCKET_HOMEWORK, BUCKET_CREDENTIALS, BUCKET_EXPORTS} {
+		if exists, err := minioClient.BucketExists(ctx, bucket); err == nil && !exists {
+			if err := minioClient.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+				log.Printf("⚠️ 创建私有桶 %s 失败: %v", bucket, err)
+			}
+		}
+	}
 }
 
-// 【修改】GenerateToken 增加入参 version
-func GenerateToken(userID uint, role string, version int) (string, error) {
+// GenerateToken 签发访问令牌，sid 关联到登录会话，会话被吊销后令牌随之失效
+func GenerateToken(userID uint, role string, version int, sessionID uint) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID,
 		"role":    role,
-		"version": version, // 【新增】将版本号写入 Token
-		"exp":     time.Now().Add(time.Hour * 72).Unix(),
+		"version": version,
+		"sid":     sessionID,
+		"exp":     time.Now().Add(ACCESS_TOKEN_TTL).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(JWT_SECRET))
+	return signJWT(claims)
 }
 
-// 【修改】AuthMiddleware 增加版本号校验
+// AuthMiddleware 校验访问令牌、令牌版本号以及所属会话是否仍然有效，也接受个人 API Key
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := bearerAPIKey(c); key != "" {
+			if authenticateAPIKey(c, key) {
+				c.Next()
+			}
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.AbortWithStatusJSON(401, gin.H{"error": "未登录"})
@@ -196,46 +313,69 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(parts[1], func(token *jwt.Token) (interface{}, error) {
-			return []byte(JWT_SECRET), nil
-		})
-
-		// ... 之前的代码 ...
-		if err != nil || !token.Valid {
+		// 带 purpose 的是两步验证等临时令牌，不能当作访问令牌使用
+		claims, err := parseJWT(parts[1])
+		if err != nil || claims["purpose"] != nil {
 			c.AbortWithStatusJSON(401, gin.H{"error": "Token无效"})
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
 		userID := uint(claims["user_id"].(float64))
 
-		// =========== 🔴 修改开始：安全获取 version ===========
+		// 没有 version 的旧 Token 视为版本 0
 		var tokenVer int
-		// 检查 "version" 字段是否存在
-		if v, ok := claims["version"]; ok {
-			tokenVer = int(v.(float64))
-		} else {
-			// 如果 Token 里没有 version (说明是旧 Token)，默认为 0
-			tokenVer = 0
+		if v, ok := claims["version"].(float64); ok {
+			tokenVer = int(v)
+		}
+		// 管理员模拟登录的令牌带有 act 声明，不属于任何会话，期间的每个请求都会记录
+		if act, ok := claims["act"].(map[string]interface{}); ok {
+			if authenticateImpersonation(c, userID, tokenVer, act) {
+				c.Next()
+				recordImpersonatedRequest(c)
+			}
+			return
 		}
-		// =========== 🔴 修改结束 ===========
 
-		// 查库校验版本号
+		// 没有 sid 的旧 Token 不属于任何会话，要求重新登录
+		sid, ok := claims["sid"].(float64)
+		if !ok {
+			c.AbortWithStatusJSON(401, gin.H{"error": "登录已失效，请重新登录"})
+			return
+		}
+		sessionID := uint(sid)
+
+		// 查库校验版本号，角色以库中为准，修改角色后无需重新登录
 		var user User
-		if err := db.Select("token_version").First(&user, userID).Error; err != nil {
+		if err := db.Select("token_version", "role", "disabled_at", "must_change_password").First(&user, userID).Error; err != nil {
 			c.AbortWithStatusJSON(401, gin.H{"error": "用户状态异常"})
 			return
 		}
-
-		// 如果 Token 版本不匹配（包括旧 Token 版本为0的情况），则踢出
 		if user.TokenVersion != tokenVer {
-			c.AbortWithStatusJSON(401, gin.H{"error": "登录已失效或账号在其他设备登录"})
+			c.AbortWithStatusJSON(401, gin.H{"error": "登录已失效，请重新登录"})
+			return
+		}
+		if user.disabled() {
+			c.AbortWithStatusJSON(403, gin.H{"error": "账号已被禁用"})
+			return
+		}
+		if user.MustChangePassword && !passwordChangePaths[c.FullPath()] {
+			c.AbortWithStatusJSON(403, gin.H{"error": "请先修改密码", "password_change_required": true})
 			return
 		}
 
+		var session Session
+		now := time.Now()
+		if err := db.First(&session, sessionID).Error; err != nil || session.UserID != userID || !session.active(now) {
+			c.AbortWithStatusJSON(401, gin.H{"error": "登录已失效或已在其他设备退出"})
+			return
+		}
+		if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+			db.Model(&session).UpdateColumns(map[string]interface{}{"last_seen_at": now, "ip": c.ClientIP()})
+		}
+
 		c.Set("userID", userID)
-		// ... 之后的代码 ...
-		c.Set("role", claims["role"].(string))
+		c.Set("sessionID", sessionID)
+		c.Set("role", user.Role)
 		c.Next()
 	}
 }
@@ -248,32 +388,31 @@ func LoginHandler(c *gin.Context) {
 	var input struct {
 		Username string
 		Password string
+		Device   string
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(400, gin.H{"error": "参数错误"})
 		return
 	}
-	var user User
-	if err := db.Unscoped().Where("username = ?", input.Username).First(&user).Error; err != nil {
-		c.JSON(401, gin.H{"error": "用户不存在"})
+	ip := c.ClientIP()
+	if wait := loginBlocked(input.Username, ip); wait > 0 {
+		loginBlockedResponse(c, wait)
 		return
 	}
-	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)) != nil {
-		c.JSON(401, gin.H{"error": "密码错误"})
-		return
+	// 用户不存在和密码错误返回同样的提示，避免被用来探测用户名
+	var user User
+	hash := dummyPasswordHash
+	found := db.Where("username = ?", input.Username).First(&user).Error == nil
+	if found {
+		hash = []byte(user.Password)
 	}
-
-	// 【修改】每次登录自增版本号
-	user.TokenVersion += 1
-	db.Model(&user).Update("token_version", user.TokenVersion)
-
-	if user.Username == "admin" {
-		user.Role = "admin"
+	if bcrypt.CompareHashAndPassword(hash, []byte(input.Password)) != nil || !found {
+		recordLoginFailure(input.Username, ip)
+		c.JSON(401, gin.H{"error": "用户名或密码错误"})
+		return
 	}
-	// 传入新版本号生成 Token
-	token, _ := GenerateToken(user.ID, user.Role, user.TokenVersion)
 
-	c.JSON(200, gin.H{"token": token, "role": user.Role, "username": user.Username, "user_id": user.ID})
+	completeLogin(c, user, input.Device)
 }
 
 // ... 其他 Handler 保持不变 ...
@@ -283,15 +422,40 @@ func LoginHandler(c *gin.Context) {
 func UpdateUserProfileHandler(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 	var req struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-		Avatar   string `json:"avatar"`
-		Bio      string `json:"bio"`
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		OldPassword string `json:"old_password"`
+		Avatar      string `json:"avatar"`
+		Bio         string `json:"bio"`
+		Email       string `json:"email"`
 	}
 	c.ShouldBindJSON(&req)
 	var user User
 	db.First(&user, userID)
+	emailChanged := false
+	if req.Email != "" {
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if user.Email == nil || *user.Email != email {
+			var count int64
+			db.Model(&User{}).Where("email = ?", email).Count(&count)
+			if count > 0 {
+				c.JSON(400, gin.H{"error": "邮箱已被使用"})
+				return
+			}
+			user.Email = &email
+			user.EmailVerifiedAt = nil
+			emailChanged = true
+		}
+	}
 	if req.Username != "" && req.Username != user.Username {
+		if reservedUsername(req.Username) {
+			c.JSON(400, gin.H{"error": "该用户名为系统保留"})
+			return
+		}
 		var count int64
 		db.Model(&User{}).Where("username = ?", req.Username).Count(&count)
 		if count > 0 {
@@ -300,9 +464,28 @@ func UpdateUserProfileHandler(c *gin.Context) {
 		}
 		user.Username = req.Username
 	}
-	if req.Password != "" {
+	passwordChanged := req.Password != ""
+	if passwordChanged {
+		if len(req.Password) < minPasswordLen {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("密码至少 %d 位", minPasswordLen)})
+			return
+		}
+		// 管理员重置后的首次改密已经用临时密码登录过，其余情况需要验证当前密码
+		if !user.MustChangePassword && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldPassword)) != nil {
+			c.JSON(400, gin.H{"error": "当前密码错误"})
+			return
+		}
+		// 管理员重置后必须换成新密码，不能沿用临时密码
+		if user.MustChangePassword && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) == nil {
+			c.JSON(400, gin.H{"error": "新密码不能与临时密码相同"})
+			return
+		}
 		hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		user.Password = string(hashedPwd)
+		user.MustChangePassword = false
+	} else if user.MustChangePassword {
+		c.JSON(400, gin.H{"error": "请先修改密码"})
+		return
 	}
 	if req.Avatar != "" {
 		user.Avatar = req.Avatar
@@ -310,26 +493,158 @@ func UpdateUserProfileHandler(c *gin.Context) {
 	if req.Bio != "" {
 		user.Bio = req.Bio
 	}
-	db.Save(&user)
-	c.JSON(200, gin.H{"message": "修改成功，请重新登录"})
+	sessionID := c.MustGet("sessionID").(uint)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if !passwordChanged {
+			return nil
+		}
+		// 修改密码后其他设备全部下线、API Key 失效，只保留当前会话
+		return revokeUserSessions(tx, user.ID, sessionID)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "保存失败"})
+		return
+	}
+	if emailChanged {
+		sendVerificationEmail(user)
+	}
+	if !passwordChanged {
+		c.JSON(200, gin.H{"message": "修改成功"})
+		return
+	}
+	resp := gin.H{"message": "密码已修改，其他设备已退出登录，API Key 已全部失效"}
+	if sessionID != 0 {
+		db.Select("token_version").First(&user, user.ID)
+		if token, err := GenerateToken(user.ID, user.Role, user.TokenVersion, sessionID); err == nil {
+			resp["token"] = token
+		}
+	}
+	c.JSON(200, resp)
 }
 
 func GetUserProfileHandler(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 	var user User
 	db.First(&user, userID)
-	c.JSON(200, gin.H{"username": user.Username, "role": user.Role, "avatar": user.Avatar, "bio": user.Bio})
+	resp := gin.H{
+		"username":             user.Username,
+		"role":                 user.Role,
+		"avatar":               user.Avatar,
+		"bio":                  user.Bio,
+		"email":                user.Email,
+		"email_verified":       user.EmailVerifiedAt != nil,
+		"totp_enabled":         user.TOTPEnabled,
+		"must_change_password": user.MustChangePassword,
+		"permissions":          rolePermissions(user.Role),
+		"sso":                  ssoAccount(user.ID),
+	}
+	// 模拟登录时告诉前端实际操作的管理员，用于显示提示条
+	if actorID, ok := c.Get("actorID"); ok {
+		resp["impersonated_by"] = actorID
+	}
+	c.JSON(200, resp)
 }
 
 // 进度更新逻辑
 type UpdateProgressReq struct {
-	CourseID   uint   `json:"course_id"`
-	Type       string `json:"type"`
-	ChapterIdx int    `json:"index"`
+	CourseID  uint   `json:"course_id"`
+	Type      string `json:"type"`
+	ChapterID uint   `json:"chapter_id"`
 }
 type ProgressDetails struct {
-	VideoDone bool  `json:"video_done"`
-	Chapters  []int `json:"chapters"`
+	VideoDone  bool   `json:"video_done"`
+	ChapterIDs []uint `json:"chapter_ids"`
+	// Chapters 旧版按章节下标记录的进度，读取时转换为 ChapterIDs
+	Chapters []int `json:"chapters,omitempty"`
+	// Quizzes 记录每个测验的最高得分率（百分比）
+	Quizzes map[uint]float64 `json:"quizzes,omitempty"`
+}
+
+// OutlineItem 对应前端大纲编辑器中的一个章节
+type OutlineItem struct {
+	Title string `json:"title"`
+	Desc  string `json:"desc"`
+}
+
+func parseOutline(outline string) []OutlineItem {
+	var items []OutlineItem
+	if outline == "" {
+		return items
+	}
+	if err := json.Unmarshal([]byte(outline), &items); err != nil {
+		return nil
+	}
+	return items
+}
+
+// calcProgress 以完成的章节数和通过的测验数计算百分比；两者都没有的课程以视频是否看完为准。
+// 已删除章节的记录不计入
+func calcProgress(details ProgressDetails, chapterIDs []uint, quizzes []Quiz) float64 {
+	total := len(chapterIDs) + len(quizzes)
+	if total == 0 {
+		if details.VideoDone {
+			return 100
+		}
+		return 0
+	}
+	done := 0
+	for _, id := range details.ChapterIDs {
+		if slices.Contains(chapterIDs, id) {
+			done++
+		}
+	}
+	for _, quiz := range quizzes {
+		if best, ok := details.Quizzes[quiz.ID]; ok && best >= quiz.PassPercent {
+			done++
+		}
+	}
+	return math.Round(float64(done)*10000/float64(total)) / 100
+}
+
+var errBadChapter = errors.New("章节不存在")
+
+// updateProgress 在行锁内读取选课进度、应用 apply 的修改并重新计算完成度，
+// 避免多个标签页或多个提交同时上报时互相覆盖
+func updateProgress(tx *gorm.DB, userID, courseID uint, apply func(details *ProgressDetails, chapterIDs []uint) error) (Enrollment, error) {
+	var enroll Enrollment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND course_id = ?", userID, courseID).First(&enroll).Error; err != nil {
+		return enroll, err
+	}
+	chapterIDs := courseChapterIDs(tx, courseID)
+	var quizzes []Quiz
+	tx.Select("id", "pass_percent").Where("course_id = ?", courseID).Find(&quizzes)
+
+	var details ProgressDetails
+	if enroll.Details != "" {
+		// 旧数据解析失败时从空进度开始，不影响本次上报
+		json.Unmarshal([]byte(enroll.Details), &details)
+	}
+	// 旧数据的章节下标按当前章节顺序转换为章节 ID
+	for _, idx := range details.Chapters {
+		if idx >= 0 && idx < len(chapterIDs) && !slices.Contains(details.ChapterIDs, chapterIDs[idx]) {
+			details.ChapterIDs = append(details.ChapterIDs, chapterIDs[idx])
+		}
+	}
+	details.Chapters = nil
+	if err := apply(&details, chapterIDs); err != nil {
+		return enroll, err
+	}
+
+	raw, _ := json.Marshal(details)
+	enroll.Details = string(raw)
+	enroll.Progress = calcProgress(details, chapterIDs, quizzes)
+	if enroll.Progress >= 100 {
+		enroll.IsFinish = true
+	}
+	return enroll, tx.Model(&enroll).Updates(map[string]interface{}{
+		"details":   enroll.Details,
+		"progress":  enroll.Progress,
+		"is_finish": enroll.IsFinish,
+	}).Error
 }
 
 func UpdateProgressHandler(c *gin.Context) {
@@ -339,34 +654,76 @@ func UpdateProgressHandler(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "参数错误"})
 		return
 	}
+	if req.Type != "video" && req.Type != "chapter" {
+		c.JSON(400, gin.H{"error": "未知的进度类型"})
+		return
+	}
+
 	var enroll Enrollment
-	if err := db.Where("user_id = ? AND course_id = ?", userID, req.CourseID).First(&enroll).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		enroll, err = updateProgress(tx, userID, req.CourseID, func(details *ProgressDetails, chapterIDs []uint) error {
+			switch req.Type {
+			case "video":
+				details.VideoDone = true
+			case "chapter":
+				if !slices.Contains(chapterIDs, req.ChapterID) {
+					return errBadChapter
+				}
+				if !slices.Contains(details.ChapterIDs, req.ChapterID) {
+					details.ChapterIDs = append(details.ChapterIDs, req.ChapterID)
+				}
+			}
+			return nil
+		})
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.JSON(404, gin.H{"error": "未找到选课记录"})
 		return
 	}
-
-	// 简单的进度计算逻辑（具体可复用之前的完整代码）
-	// 这里做个示例：更新 details 并保存
-	// 实际项目中建议使用 json.Unmarshal 解析 Details
-	c.JSON(200, gin.H{"progress": enroll.Progress, "details": enroll.Details})
+	if errors.Is(err, errBadChapter) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "进度保存失败"})
+		return
+	}
+	c.JSON(200, gin.H{"progress": enroll.Progress, "is_finish": enroll.IsFinish, "details": enroll.Details})
 }
 
 func RegisterHandler(c *gin.Context) {
-	var input struct{ Username, Password, Role string }
+	var input struct{ Username, Password, Email string }
 	c.ShouldBindJSON(&input)
-	if input.Role == "admin" || input.Username == "admin" {
+	if input.Username == "admin" {
 		c.JSON(403, gin.H{"error": "无法注册管理员"})
 		return
 	}
-	if input.Role == "" {
-		input.Role = "student"
+	if reservedUsername(input.Username) {
+		c.JSON(400, gin.H{"error": "该用户名为系统保留"})
+		return
+	}
+	if len(input.Password) < minPasswordLen {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("密码至少 %d 位", minPasswordLen)})
+		return
 	}
 	hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
-	user := User{Username: input.Username, Password: string(hashedPwd), Role: input.Role}
+	// 注册一律为学生，需要授课的用户通过教师申请由管理员审核
+	user := User{Username: input.Username, Password: string(hashedPwd), Role: "student"}
+	if input.Email != "" {
+		email, err := normalizeEmail(input.Email)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		user.Email = &email
+	}
 	if err := db.Create(&user).Error; err != nil {
-		c.JSON(500, gin.H{"error": "用户已存在"})
+		c.JSON(500, gin.H{"error": "用户名或邮箱已存在"})
 		return
 	}
+	sendVerificationEmail(user)
 	c.JSON(200, gin.H{"message": "注册成功"})
 }
 
@@ -387,33 +744,107 @@ func ListCoursesHandler(c *gin.Context) {
 	c.JSON(200, gin.H{"data": courses})
 }
 
+// paramID 解析路径中的数字 ID，格式不对时返回 400。
+// 原始字符串不能直接传给 First，非数字的值会被 GORM 当作 SQL 条件拼进查询
+func paramID(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// viewerID 公开接口中识别可选的登录令牌，未登录或令牌无效时返回 0
+func viewerID(c *gin.Context) uint {
+	tokenStr, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return 0
+	}
+	claims, err := parseJWT(tokenStr)
+	if err != nil || claims["purpose"] != nil {
+		return 0
+	}
+	uid, _ := claims["user_id"].(float64)
+	return uint(uid)
+}
+
+// courseVisible 未上线的课程只有授课教师和有审核、管理权限的用户可以查看
+func courseVisible(c *gin.Context, course Course) bool {
+	if course.Status == 1 {
+		return true
+	}
+	uid := viewerID(c)
+	if uid == 0 {
+		return false
+	}
+	if course.TeacherID == uid {
+		return true
+	}
+	var user User
+	if err := db.Select("role", "disabled_at").First(&user, uid).Error; err != nil || user.disabled() {
+		return false
+	}
+	return hasPermission(user.Role, PermCourseManageAny) || hasPermission(user.Role, PermCourseAudit)
+}
+
 func GetCourseDetailHandler(c *gin.Context) {
-	courseID := c.Param("id")
+	courseID, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
 	var course Course
-	if err := db.Preload("Teacher").First(&course, courseID).Error; err != nil {
+	if err := preloadChapters(db).Preload("Teacher").First(&course, courseID).Error; err != nil || !courseVisible(c, course) {
 		c.JSON(404, gin.H{"error": "课程不存在"})
 		return
 	}
 	db.Model(&course).UpdateColumn("view_count", gorm.Expr("view_count + ?", 1))
 	isEnrolled := false
-	authHeader := c.GetHeader("Authorization")
-	if authHeader != "" && strings.Contains(authHeader, "Bearer ") {
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		token, _ := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) { return []byte(JWT_SECRET), nil })
-		if token != nil && token.Valid {
-			claims := token.Claims.(jwt.MapClaims)
-			uid := uint(claims["user_id"].(float64))
-			var count int64
-			db.Model(&Enrollment{}).Where("user_id = ? AND course_id = ?", uid, course.ID).Count(&count)
-			if count > 0 {
-				isEnrolled = true
-			}
+	if uid := viewerID(c); uid != 0 {
+		var count int64
+		db.Model(&Enrollment{}).Where("user_id = ? AND course_id = ?", uid, course.ID).Count(&count)
+		if count > 0 {
+			isEnrolled = true
 		}
 	}
 	course.Teacher.Password = ""
 	c.JSON(200, gin.H{"course": course, "is_enrolled": isEnrolled})
 }
 
+// contentTypeOf 根据扩展名推断 Content-Type
+func contentTypeOf(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mp4":
+		return "video/mp4" // 关键修正：告诉浏览器这是mp4视频
+	case ".avi":
+		return "video/x-msvideo"
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".pdf":
+		return "application/pdf"
+	case ".zip":
+		return "application/zip"
+	case ".txt":
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// putUploadedFile 把表单文件写入指定桶，返回对象名
+func putUploadedFile(bucket string, file *multipart.FileHeader) (string, error) {
+	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(file.Filename))
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	_, err = minioClient.PutObject(context.Background(), bucket, filename, src, file.Size,
+		minio.PutObjectOptions{ContentType: contentTypeOf(file.Filename)})
+	return filename, err
+}
+
 func UploadHandler(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -421,27 +852,11 @@ func UploadHandler(c *gin.Context) {
 		return
 	}
 	bucket := BUCKET_PICTURES
-	contentType := "application/octet-stream" // 默认值
-
-	// 判断类型并设置正确的 Content-Type
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if ext == ".mp4" {
+	if strings.HasPrefix(contentTypeOf(file.Filename), "video/") {
 		bucket = BUCKET_VIDEOS
-		contentType = "video/mp4" // 关键修正：告诉浏览器这是mp4视频
-	} else if ext == ".avi" {
-		bucket = BUCKET_VIDEOS
-		contentType = "video/x-msvideo"
-	} else if ext == ".png" {
-		contentType = "image/png"
-	} else if ext == ".jpg" || ext == ".jpeg" {
-		contentType = "image/jpeg"
 	}
 
-	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), file.Filename)
-	src, _ := file.Open()
-	defer src.Close()
-	_, err = minioClient.PutObject(context.Background(), bucket, filename, src, file.Size,
-		minio.PutObjectOptions{ContentType: contentType})
+	filename, err := putUploadedFile(bucket, file)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "上传失败"})
 		return
@@ -455,20 +870,27 @@ func CreateCourseHandler(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	role := c.MustGet("role").(string)
 	course.ViewCount = 0
-	if role == "admin" {
+	if can(c, PermCoursePublish) {
 		course.Status = 1
 	} else {
 		course.Status = 0
 	}
 	db.Create(&course)
+	if course.Outline != "" {
+		importOutline(db, course.ID, course.Outline)
+	}
+	if course.HomeworkReq != "" {
+		defaultAssignment(db, course)
+	}
 	c.JSON(200, gin.H{"message": "发布成功，等待审核"})
 }
 
 func UpdateCourseHandler(c *gin.Context) {
-	id := c.Param("id")
-	userRole := c.MustGet("role").(string)
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
 	userID := c.MustGet("userID").(uint)
 	var req Course
 	c.ShouldBindJSON(&req)
@@ -477,11 +899,29 @@ func UpdateCourseHandler(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "课程不存在"})
 		return
 	}
-	if userRole != "admin" && course.TeacherID != userID {
+	if course.TeacherID != userID && !can(c, PermCourseManageAny) {
 		c.JSON(403, gin.H{"error": "权限不足"})
 		return
 	}
-	db.Model(&course).Updates(req)
+	// 大纲已迁移为章节，修改章节请使用章节接口
+	if req.Outline != "" {
+		c.JSON(400, gin.H{"error": "课程大纲已改为章节管理，请通过章节接口修改"})
+		return
+	}
+	before := courseSnapshot(course)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&course).Omit(clause.Associations).Updates(req).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&course, course.ID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditCourseUpdate, "course", course.ID, before, courseSnapshot(course))
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "更新失败"})
+		return
+	}
 	c.JSON(200, gin.H{"message": "更新成功"})
 }
 
@@ -514,28 +954,84 @@ func GetMyCoursesHandler(c *gin.Context) {
 
 func SubmitHomeworkHandler(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
-	var hw Homework
-	c.ShouldBindJSON(&hw)
-	hw.StudentID = userID
-	var exist Homework
-	if db.Where("course_id = ? AND student_id = ?", hw.CourseID, userID).First(&exist).Error == nil {
-		exist.Content = hw.Content
-		db.Save(&exist)
+	var req struct {
+		AssignmentID  uint   `json:"assignment_id"`
+		CourseID      uint   `json:"course_id"`
+		Content       string `json:"content"`
+		AttachmentIDs []uint `json:"attachment_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	var assignment Assignment
+	if req.AssignmentID != 0 {
+		if err := db.First(&assignment, req.AssignmentID).Error; err != nil {
+			c.JSON(404, gin.H{"error": "作业不存在"})
+			return
+		}
 	} else {
-		db.Create(&hw)
+		// 兼容只传 course_id 的旧客户端：提交到课程的默认作业
+		var course Course
+		if err := db.First(&course, req.CourseID).Error; err != nil {
+			c.JSON(404, gin.H{"error": "课程不存在"})
+			return
+		}
+		var err error
+		if assignment, err = defaultAssignment(db, course); err != nil {
+			c.JSON(500, gin.H{"error": "提交失败"})
+			return
+		}
+	}
+
+	var count int64
+	db.Model(&Enrollment{}).Where("user_id = ? AND course_id = ?", userID, assignment.CourseID).Count(&count)
+	if count == 0 {
+		c.JSON(403, gin.H{"error": "请先加入课程"})
+		return
 	}
-	c.JSON(200, gin.H{"message": "提交成功"})
+	isLate := assignment.IsClosed(time.Now())
+	if isLate && assignment.LatePolicy == LatePolicyReject {
+		c.JSON(400, gin.H{"error": "已过截止时间，无法提交"})
+		return
+	}
+
+	var hw Homework
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		hw, err = saveSubmission(tx, assignment, userID, req.Content, isLate, req.AttachmentIDs)
+		return err
+	})
+	if errors.Is(err, errBadAttachment) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "提交失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "提交成功", "is_late": isLate, "revision": hw.Revision})
 }
 
 func GetHomeworkHandler(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
-	courseID := c.Query("course_id")
+	tx := db.Where("student_id = ?", userID)
+	if assignmentID := c.Query("assignment_id"); assignmentID != "" {
+		tx = tx.Where("assignment_id = ?", assignmentID)
+	} else {
+		tx = tx.Where("course_id = ?", c.Query("course_id")).Order("id desc")
+	}
 	var hw Homework
-	if err := db.Where("course_id = ? AND student_id = ?", courseID, userID).First(&hw).Error; err != nil {
+	if err := tx.First(&hw).Error; err != nil {
 		c.JSON(200, gin.H{"exists": false})
 		return
 	}
-	c.JSON(200, gin.H{"exists": true, "data": hw})
+	c.JSON(200, gin.H{
+		"exists":        true,
+		"data":          hw,
+		"attachments":   currentAttachments(hw),
+		"rubric_scores": rubricBreakdown(hw),
+	})
 }
 
 func CreateQuestionHandler(c *gin.Context) {
@@ -552,51 +1048,132 @@ func CreateQuestionHandler(c *gin.Context) {
 func GetCourseQuestionsHandler(c *gin.Context) {
 	courseID := c.Query("course_id")
 	var questions []Question
-	db.Preload("Student").Where("course_id = ?", courseID).Order("created_at desc").Find(&questions)
+	db.Preload("Student", withDeleted).Where("course_id = ?", courseID).Order("created_at desc").Find(&questions)
 	c.JSON(200, gin.H{"data": questions})
 }
 
 func ReplyQuestionHandler(c *gin.Context) {
 	teacherID := c.MustGet("userID").(uint)
-	role := c.MustGet("role").(string)
-	if role != "teacher" && role != "admin" {
-		c.JSON(403, gin.H{"error": "无权回复"})
-		return
-	}
 	var req struct {
 		ID     uint   `json:"id"`
 		Answer string `json:"answer"`
 	}
 	c.ShouldBindJSON(&req)
-	db.Model(&Question{}).Where("id = ?", req.ID).Updates(map[string]interface{}{"answer": req.Answer, "teacher_id": teacherID, "is_answered": true})
+	var question Question
+	if err := db.First(&question, req.ID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "问题不存在"})
+		return
+	}
+	before := questionSnapshot(question)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&question).Updates(map[string]interface{}{"answer": req.Answer, "teacher_id": teacherID, "is_answered": true}).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditQuestionReply, "question", question.ID, before, questionSnapshot(question))
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "回复失败"})
+		return
+	}
 	c.JSON(200, gin.H{"message": "回复成功"})
 }
 
 func GradeHomeworkHandler(c *gin.Context) {
-	role := c.MustGet("role").(string)
-	if role != "teacher" && role != "admin" {
-		c.JSON(403, gin.H{"error": "无权批改"})
-		return
-	}
+	graderID := c.MustGet("userID").(uint)
 	var req struct {
-		ID      uint   `json:"id"`
-		Score   int    `json:"score"`
-		Comment string `json:"comment"`
+		ID      uint              `json:"id"`
+		Score   int               `json:"score"`
+		Comment string            `json:"comment"`
+		Action  string            `json:"action"` // grade（默认）或 return 退回修改
+		Levels  []RubricSelection `json:"levels"` // 作业设置了量规时按等级评分，总分由服务端计算
 	}
 	c.ShouldBindJSON(&req)
-	db.Model(&Homework{}).Where("id = ?", req.ID).Updates(map[string]interface{}{"score": req.Score, "comment": req.Comment})
-	c.JSON(200, gin.H{"message": "批改完成"})
+	var hw Homework
+	if err := db.First(&hw, req.ID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "作业不存在"})
+		return
+	}
+	var course Course
+	if err := db.Select("id", "teacher_id").First(&course, hw.CourseID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "课程不存在"})
+		return
+	}
+	if course.TeacherID != graderID && !can(c, PermCourseManageAny) {
+		c.JSON(403, gin.H{"error": "只能批改自己课程的作业"})
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"comment":         req.Comment,
+		"graded_at":       &now,
+		"grader_id":       graderID,
+		"graded_revision": hw.Revision,
+	}
+	var rubricScores []HomeworkRubricScore
+	switch req.Action {
+	case "", "grade":
+		var assignment Assignment
+		if db.First(&assignment, hw.AssignmentID).Error == nil {
+			if criteria := loadRubric(assignment.ID); len(criteria) > 0 {
+				score, scores, err := scoreByRubric(criteria, req.Levels)
+				if err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+				req.Score, rubricScores = score, scores
+			}
+			if req.Score < 0 || req.Score > assignment.MaxScore {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("分数需在 0-%d 之间", assignment.MaxScore)})
+				return
+			}
+		}
+		updates["score"] = req.Score
+		updates["status"] = HomeworkGraded
+	case "return":
+		if req.Comment == "" {
+			c.JSON(400, gin.H{"error": "退回修改需填写评语"})
+			return
+		}
+		updates["status"] = HomeworkReturned
+	default:
+		c.JSON(400, gin.H{"error": "未知的批改操作"})
+		return
+	}
+	before := homeworkSnapshot(hw)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&hw).Updates(updates).Error; err != nil {
+			return err
+		}
+		if rubricScores != nil {
+			// 重新批改时覆盖上一次的逐项得分
+			if err := tx.Unscoped().Where("homework_id = ?", hw.ID).Delete(&HomeworkRubricScore{}).Error; err != nil {
+				return err
+			}
+			for i := range rubricScores {
+				rubricScores[i].HomeworkID = hw.ID
+			}
+			if err := tx.Create(&rubricScores).Error; err != nil {
+				return err
+			}
+		}
+		after := homeworkSnapshot(hw)
+		if rubricScores != nil {
+			after["rubric"] = rubricScores
+		}
+		return recordAudit(tx, c, AuditHomeworkGrade, "homework", hw.ID, before, after)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "批改失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "批改完成", "score": req.Score})
 }
 
 func GetTeacherDashboardHandler(c *gin.Context) {
 	teacherID := c.MustGet("userID").(uint)
-	role := c.MustGet("role").(string)
-	if role != "teacher" && role != "admin" {
-		c.JSON(403, gin.H{"error": "权限不足"})
-		return
-	}
 	var courseIDs []uint
-	if role == "admin" {
+	if can(c, PermCourseManageAny) {
 		db.Model(&Course{}).Pluck("id", &courseIDs)
 	} else {
 		db.Model(&Course{}).Where("teacher_id = ?", teacherID).Pluck("id", &courseIDs)
@@ -606,18 +1183,13 @@ func GetTeacherDashboardHandler(c *gin.Context) {
 		return
 	}
 	var homeworks []Homework
-	db.Where("course_id IN ? AND score = 0", courseIDs).Find(&homeworks)
+	db.Where("course_id IN ? AND status IN ?", courseIDs, []string{HomeworkSubmitted, HomeworkResubmitted}).Find(&homeworks)
 	var questions []Question
-	db.Preload("Student").Where("course_id IN ? AND is_answered = ?", courseIDs, false).Find(&questions)
+	db.Preload("Student", withDeleted).Where("course_id IN ? AND is_answered = ?", courseIDs, false).Find(&questions)
 	c.JSON(200, gin.H{"homeworks": homeworks, "questions": questions})
 }
 
 func AdminStatsHandler(c *gin.Context) {
-	role := c.MustGet("role").(string)
-	if role != "admin" {
-		c.JSON(403, gin.H{"error": "权限不足"})
-		return
-	}
 	var userCount, courseCount, pendingCount int64
 	var totalViews int64
 	db.Model(&User{}).Count(&userCount)
@@ -628,15 +1200,13 @@ func AdminStatsHandler(c *gin.Context) {
 	}
 	var pendingCourses []Course
 	db.Preload("Teacher").Where("status = ?", 0).Order("created_at desc").Find(&pendingCourses)
-	c.JSON(200, gin.H{"user_count": userCount, "course_count": courseCount, "view_count": totalViews, "pending_count": pendingCount, "pending_list": pendingCourses})
+	var applications []TeacherApplication
+	db.Preload("User").Where("status = ?", ApplicationPending).Order("created_at asc").Find(&applications)
+	c.JSON(200, gin.H{"user_count": userCount, "course_count": courseCount, "view_count": totalViews, "pending_count": pendingCount, "pending_list": pendingCourses,
+		"application_count": len(applications), "application_list": applications})
 }
 
 func AdminAuditCourseHandler(c *gin.Context) {
-	role := c.MustGet("role").(string)
-	if role != "admin" {
-		c.JSON(403, gin.H{"error": "权限不足"})
-		return
-	}
 	var req struct {
 		ID     uint `json:"id"`
 		Status int  `json:"status"`
@@ -645,16 +1215,52 @@ func AdminAuditCourseHandler(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "参数错误"})
 		return
 	}
-	db.Model(&Course{}).Where("id = ?", req.ID).Update("status", req.Status)
+	var course Course
+	if err := db.First(&course, req.ID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "课程不存在"})
+		return
+	}
+	before := gin.H{"status": course.Status}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&course).Update("status", req.Status).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditCourseAudit, "course", course.ID, before, gin.H{"status": course.Status})
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "操作失败"})
+		return
+	}
 	c.JSON(200, gin.H{"message": "操作成功"})
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		runCreateAdmin(os.Args[2:])
+		return
+	}
+
 	initConfig()
+	initJWTKeys()
 	initDB()
 	initMinIO()
+	initMailer()
+	initLoginGuard()
+	initOIDC()
+	startExamSweeper()
+	startDataExportWorker()
+
+	setupRouter().Run(":8080")
+}
 
+// setupRouter 注册中间件和全部路由
+func setupRouter() *gin.Engine {
 	r := gin.Default()
+	// nginx 通过 X-Real-IP 传递客户端地址，仅在请求来自可信代理时采用
+	r.RemoteIPHeaders = []string{"X-Real-IP", "X-Forwarded-For"}
+	if err := r.SetTrustedProxies(TRUSTED_PROXIES); err != nil {
+		log.Fatal("TRUSTED_PROXIES 配置错误: ", err)
+	}
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
@@ -666,35 +1272,123 @@ func main() {
 		c.Next()
 	})
 
+	r.GET("/.well-known/jwks.json", JWKSHandler)
+
 	api := r.Group("/api/v1")
 	{
 		api.POST("/register", RegisterHandler)
 		api.POST("/login", LoginHandler)
+		api.POST("/login/2fa", LoginMFAHandler)
+		api.POST("/login/2fa/setup", LoginMFASetupHandler)
+		api.GET("/oidc/login", OIDCLoginHandler)
+		api.GET("/oidc/callback", OIDCCallbackHandler)
+		api.POST("/oidc/exchange", SSOExchangeHandler)
+		api.POST("/token/refresh", RefreshTokenHandler)
+		api.POST("/email/verify", VerifyEmailHandler)
+		api.POST("/password/forgot", ForgotPasswordHandler)
+		api.POST("/password/reset", ResetPasswordHandler)
 		api.GET("/courses", ListCoursesHandler)
 		api.GET("/courses/:id", GetCourseDetailHandler)
+		api.GET("/courses/:id/chapters", ListChaptersHandler)
 
 		auth := api.Group("/")
 		auth.Use(AuthMiddleware())
 		{
 			auth.POST("/upload", UploadHandler)
-			auth.POST("/courses", CreateCourseHandler)
+			auth.POST("/courses", RequirePermission(PermCourseCreate), CreateCourseHandler)
 			auth.PUT("/courses/:id", UpdateCourseHandler)
+			auth.POST("/courses/:id/chapters", CreateChapterHandler)
+			auth.PUT("/courses/:id/chapters/reorder", ReorderChaptersHandler)
+			auth.PUT("/courses/:id/chapters/:cid", UpdateChapterHandler)
+			auth.DELETE("/courses/:id/chapters/:cid", DeleteChapterHandler)
+			auth.POST("/courses/:id/chapters/:cid/lessons", CreateLessonHandler)
+			auth.PUT("/courses/:id/chapters/:cid/lessons/reorder", ReorderLessonsHandler)
+			auth.PUT("/courses/:id/chapters/:cid/lessons/:lid", UpdateLessonHandler)
+			auth.DELETE("/courses/:id/chapters/:cid/lessons/:lid", DeleteLessonHandler)
 			auth.POST("/enroll", EnrollHandler)
 			auth.GET("/my-courses", GetMyCoursesHandler)
+			auth.GET("/courses/:id/assignments", ListAssignmentsHandler)
+			auth.POST("/courses/:id/assignments", CreateAssignmentHandler)
+			auth.PUT("/courses/:id/assignments/:aid", UpdateAssignmentHandler)
+			auth.DELETE("/courses/:id/assignments/:aid", DeleteAssignmentHandler)
+			auth.GET("/courses/:id/assignments/:aid/rubric", GetRubricHandler)
+			auth.PUT("/courses/:id/assignments/:aid/rubric", SaveRubricHandler)
+			auth.GET("/courses/:id/question-bank", ListBankQuestionsHandler)
+			auth.POST("/courses/:id/question-bank", CreateBankQuestionHandler)
+			auth.PUT("/courses/:id/question-bank/:qid", UpdateBankQuestionHandler)
+			auth.DELETE("/courses/:id/question-bank/:qid", DeleteBankQuestionHandler)
+			auth.GET("/courses/:id/gradebook", GradebookHandler)
+			auth.GET("/courses/:id/quizzes", ListQuizzesHandler)
+			auth.POST("/courses/:id/quizzes", CreateQuizHandler)
+			auth.PUT("/courses/:id/quizzes/:qid", UpdateQuizHandler)
+			auth.DELETE("/courses/:id/quizzes/:qid", DeleteQuizHandler)
+			auth.POST("/quizzes/:id/start", StartQuizHandler)
+			auth.GET("/quiz-attempts/:id", GetQuizAttemptHandler)
+			auth.PUT("/quiz-attempts/:id/answers", SaveAttemptAnswersHandler)
+			auth.POST("/quiz-attempts/:id/submit", SubmitQuizAttemptHandler)
 			auth.POST("/homework", SubmitHomeworkHandler)
 			auth.GET("/homework", GetHomeworkHandler)
 			auth.POST("/questions", CreateQuestionHandler)
 			auth.GET("/questions", GetCourseQuestionsHandler)
-			auth.PUT("/questions/reply", ReplyQuestionHandler)
-			auth.PUT("/homework/grade", GradeHomeworkHandler)
-			auth.GET("/teacher/dashboard", GetTeacherDashboardHandler)
-			auth.GET("/admin/stats", AdminStatsHandler)
-			auth.PUT("/admin/audit", AdminAuditCourseHandler)
-
+			auth.PUT("/questions/reply", RequirePermission(PermQuestionReply), ReplyQuestionHandler)
+			auth.PUT("/homework/grade", RequirePermission(PermHomeworkGrade), GradeHomeworkHandler)
+			auth.GET("/homework/:id/revisions", ListHomeworkRevisionsHandler)
+			auth.POST("/homework/attachments", UploadHomeworkAttachmentHandler)
+			auth.GET("/homework/attachments/:id/url", HomeworkAttachmentURLHandler)
+			auth.GET("/homework/:id/diff", DiffHomeworkRevisionsHandler)
+			auth.POST("/teacher-applications", ApplyTeacherHandler)
+			auth.GET("/teacher-applications/mine", MyTeacherApplicationHandler)
+			auth.GET("/admin/teacher-applications", RequirePermission(PermTeacherReview), ListTeacherApplicationsHandler)
+			auth.PUT("/admin/teacher-applications/:id", RequirePermission(PermTeacherReview), ReviewTeacherApplicationHandler)
+			auth.GET("/admin/teacher-applications/:id/credential", RequirePermission(PermTeacherReview), TeacherApplicationCredentialHandler)
+			auth.GET("/teacher/dashboard", RequirePermission(PermHomeworkGrade), GetTeacherDashboardHandler)
+			auth.GET("/admin/stats", RequirePermission(PermStatsView), AdminStatsHandler)
+			auth.PUT("/admin/audit", RequirePermission(PermCourseAudit), AdminAuditCourseHandler)
+
+			auth.POST("/logout", LogoutHandler)
+			auth.GET("/user/sessions", ListMySessionsHandler)
+			auth.DELETE("/user/sessions", RevokeOtherSessionsHandler)
+			auth.DELETE("/user/sessions/:id", RevokeMySessionHandler)
+			auth.GET("/admin/users/:id/sessions", RequirePermission(PermUserManage), AdminListUserSessionsHandler)
+			auth.DELETE("/admin/users/:id/sessions", RequirePermission(PermUserManage), AdminRevokeAllUserSessionsHandler)
+			auth.DELETE("/admin/users/:id/sessions/:sid", RequirePermission(PermUserManage), AdminRevokeUserSessionHandler)
+			auth.GET("/admin/users", RequirePermission(PermUserManage), AdminListUsersHandler)
+			auth.POST("/admin/users", RequirePermission(PermUserManage), AdminCreateUserHandler)
+			auth.GET("/admin/users/:id", RequirePermission(PermUserManage), AdminGetUserHandler)
+			auth.PUT("/admin/users/:id", RequirePermission(PermUserManage), AdminUpdateUserHandler)
+			auth.DELETE("/admin/users/:id", RequirePermission(PermUserManage), AdminDeleteUserHandler)
+			auth.POST("/admin/users/:id/disable", RequirePermission(PermUserManage), AdminDisableUserHandler)
+			auth.POST("/admin/users/:id/enable", RequirePermission(PermUserManage), AdminEnableUserHandler)
+			auth.POST("/admin/users/:id/reset-password", RequirePermission(PermUserManage), AdminResetUserPasswordHandler)
+			auth.POST("/admin/users/:id/impersonate", RequirePermission(PermUserImpersonate), StartImpersonationHandler)
+			auth.GET("/admin/audit-events", RequirePermission(PermAuditView), ListAuditEventsHandler)
+			auth.GET("/admin/impersonations", RequirePermission(PermUserImpersonate), ListImpersonationsHandler)
+			auth.GET("/admin/impersonations/:id", RequirePermission(PermUserImpersonate), GetImpersonationHandler)
+			auth.DELETE("/admin/impersonations/:id", RequirePermission(PermUserImpersonate), EndImpersonationHandler)
+			auth.POST("/admin/users/:id/unlock", RequirePermission(PermUserManage), AdminUnlockUserHandler)
+			auth.PUT("/admin/users/:id/role", RequirePermission(PermUserManage), AssignUserRoleHandler)
+			auth.GET("/admin/permissions", RequirePermission(PermRoleManage), ListPermissionsHandler)
+			auth.GET("/admin/roles", RequirePermission(PermRoleManage), ListRolesHandler)
+			auth.POST("/admin/roles", RequirePermission(PermRoleManage), CreateRoleHandler)
+			auth.PUT("/admin/roles/:id", RequirePermission(PermRoleManage), UpdateRoleHandler)
+			auth.DELETE("/admin/roles/:id", RequirePermission(PermRoleManage), DeleteRoleHandler)
+			auth.GET("/user/api-keys", ListAPIKeysHandler)
+			auth.POST("/user/api-keys", CreateAPIKeyHandler)
+			auth.DELETE("/user/api-keys/:id", RevokeAPIKeyHandler)
+			auth.POST("/user/data-export", RequestDataExportHandler)
+			auth.GET("/user/data-export", ListDataExportsHandler)
+			auth.GET("/user/data-export/:id/url", DataExportURLHandler)
+			auth.POST("/user/account/deletion-code", SendAccountDeletionCodeHandler)
+			auth.DELETE("/user/account", DeleteAccountHandler)
 			auth.GET("/user/profile", GetUserProfileHandler)
 			auth.PUT("/user/profile", UpdateUserProfileHandler)
+			auth.POST("/user/email/resend", ResendVerificationHandler)
+			auth.POST("/user/2fa/setup", SetupTOTPHandler)
+			auth.POST("/user/2fa/enable", EnableTOTPHandler)
+			auth.POST("/user/2fa/disable", DisableTOTPHandler)
+			auth.POST("/user/2fa/recovery-codes", RegenerateRecoveryCodesHandler)
 			auth.POST("/progress/update", UpdateProgressHandler)
 		}
 	}
-	r.Run(":8080")
+	return r
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testPassword = "password123"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	log.SetOutput(io.Discard)
+
+	dir, err := os.MkdirTemp("", "edu-test-keys")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("JWT_KEYS_DIR", dir)
+	initJWTKeys()
+	loginStore = newMemoryStore()
+	// 测试中不等待失败延迟
+	loginDelayAfter = 1000
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setupTestDB 为每个测试准备独立的 SQLite 数据库并写入内置角色
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000&_foreign_keys=off"
+	var err error
+	db, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := autoMigrate(db); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	migrateRoles()
+	t.Cleanup(invalidatePermissions)
+}
+
+func createTestUser(t *testing.T, username, role string) User {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	user := User{Username: username, Password: string(hash), Role: role}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	return user
+}
+
+// createTestRole 创建只包含指定权限点的自定义角色
+func createTestRole(t *testing.T, name string, perms ...string) {
+	t.Helper()
+	var permissions []Permission
+	db.Where("code IN ?", perms).Find(&permissions)
+	if err := db.Create(&Role{Name: name, DisplayName: name, Permissions: permissions}).Error; err != nil {
+		t.Fatalf("创建角色失败: %v", err)
+	}
+	invalidatePermissions()
+}
+
+func createTestSession(t *testing.T, user User) Session {
+	t.Helper()
+	now := time.Now()
+	_, refreshHash := newRefreshToken()
+	session := Session{UserID: user.ID, RefreshHash: refreshHash, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+	return session
+}
+
+// loginAs 为用户创建会话并返回访问令牌
+func loginAs(t *testing.T, user User) string {
+	t.Helper()
+	session := createTestSession(t, user)
+	token, err := GenerateToken(user.ID, user.Role, user.TokenVersion, session.ID)
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+	return token
+}
+
+func createTestCourse(t *testing.T, teacher User) Course {
+	t.Helper()
+	course := Course{Title: "Go 入门", TeacherID: teacher.ID, Status: 1}
+	if err := db.Create(&course).Error; err != nil {
+		t.Fatalf("创建课程失败: %v", err)
+	}
+	return course
+}
+
+// newJSONRequest 构造 JSON 请求，token 为空时不带 Authorization 头
+func newJSONRequest(method, path, token string, body interface{}) *http.Request {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func doRequest(r http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	return serve(r, newJSONRequest(method, path, token, body))
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("响应不是 JSON: %s", w.Body.String())
+	}
+	return body
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// TOTP 两步验证（RFC 6238）
+// ===========================
+
+const (
+	totpIssuer        = "EduPlatform"
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1 // 允许前后各偏差一个时间窗口
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// RecoveryCode 两步验证的一次性恢复码，只保存 bcrypt 哈希
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint `gorm:"index"`
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+var errBadMFACode = errors.New("验证码错误")
+
+func newTOTPSecret() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+}
+
+// totpCode 计算指定时间步的验证码
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// verifyTOTP 校验验证码，返回匹配的时间步；lastStep 之前（含）的时间步视为已用过，防止重放
+func verifyTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func provisioningURI(user User, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + user.Username)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// mfaRequired 判断角色是否被强制要求两步验证
+func mfaRequired(role string) bool {
+	return slices.Contains(MFA_REQUIRED_ROLES, role)
+}
+
+// generateRecoveryCodes 重新生成恢复码，旧的恢复码全部作废
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]RecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		buf := make([]byte, 5)
+		rand.Read(buf)
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+		code = code[:4] + "-" + code[4:]
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		rows = append(rows, RecoveryCode{UserID: userID, CodeHash: string(hash)})
+	}
+	return codes, tx.Create(&rows).Error
+}
+
+// useRecoveryCode 核销一个未使用的恢复码
+func useRecoveryCode(userID uint, code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+	var rows []RecoveryCode
+	db.Where("user_id = ? AND used_at IS NULL", userID).Find(&rows)
+	for _, r := range rows {
+		if bcrypt.CompareHashAndPassword([]byte(r.CodeHash), []byte(code)) == nil {
+			res := db.Model(&RecoveryCode{}).Where("id = ? AND used_at IS NULL", r.ID).Update("used_at", time.Now())
+			return res.Error == nil && res.RowsAffected > 0
+		}
+	}
+	return false
+}
+
+// checkTOTP 校验用户的验证码并记录已用时间步
+func checkTOTP(user User, code string) bool {
+	step, ok := verifyTOTP(user.TOTPSecret, code, user.TOTPLastStep, time.Now())
+	if !ok {
+		return false
+	}
+	// 条件更新防止同一验证码被并发使用两次
+	res := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+// generateMFAToken 密码校验通过后签发的临时令牌，只能用于完成两步验证
+func generateMFAToken(user User, device string) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": "mfa",
+		"device":  device,
+		"exp":     time.Now().Add(mfaTokenTTL).Unix(),
+	}
+	return signJWT(claims)
+}
+
+func parseMFAToken(tokenStr string) (User, string, error) {
+	var user User
+	claims, err := parseJWT(tokenStr)
+	if err != nil || claims["purpose"] != "mfa" {
+		return user, "", errors.New("验证已过期，请重新登录")
+	}
+	uid, _ := claims["user_id"].(float64)
+	if err := db.First(&user, uint(uid)).Error; err != nil || user.disabled() {
+		return user, "", errors.New("用户状态异常")
+	}
+	device, _ := claims["device"].(string)
+	return user, device, nil
+}
+
+// completeLogin 密码校验通过后调用：需要两步验证时返回临时令牌，否则直接创建会话
+func completeLogin(c *gin.Context, user User, device string) {
+	if user.disabled() {
+		c.JSON(403, gin.H{"error": "账号已被禁用"})
+		return
+	}
+	if user.TOTPEnabled || mfaRequired(user.Role) {
+		mfaToken, err := generateMFAToken(user, device)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "登录失败"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"mfa_required":       true,
+			"mfa_setup_required": !user.TOTPEnabled, // 角色强制要求但尚未绑定，需要先完成绑定
+			"mfa_token":          mfaToken,
+		})
+		return
+	}
+	// 需要两步验证时失败计数留到第二步成功后再清空
+	recordLoginSuccess(user.Username)
+	resp, err := issueSession(c, user, device)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "登录失败"})
+		return
+	}
+	c.JSON(200, resp)
+}
+
+// LoginMFAHandler 登录第二步：校验验证码或恢复码后签发令牌。
+// 对被强制要求但尚未绑定的账号，首次校验成功即视为完成绑定并返回恢复码
+func LoginMFAHandler(c *gin.Context) {
+	var req struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	user, device, err := parseMFAToken(req.MFAToken)
+	if err != nil {
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	}
+	// 验证码同样计入登录失败次数，防止在临时令牌有效期内穷举
+	ip := c.ClientIP()
+	if wait := loginBlocked(user.Username, ip); wait > 0 {
+		loginBlockedResponse(c, wait)
+		return
+	}
+
+	var recoveryCodes []string
+	switch {
+	case user.TOTPEnabled && req.RecoveryCode != "":
+		if !useRecoveryCode(user.ID, req.RecoveryCode) {
+			recordLoginFailure(user.Username, ip)
+			c.JSON(401, gin.H{"error": "恢复码无效"})
+			return
+		}
+	case user.TOTPEnabled:
+		if !checkTOTP(user, req.Code) {
+			recordLoginFailure(user.Username, ip)
+			c.JSON(401, gin.H{"error": errBadMFACode.Error()})
+			return
+		}
+	default:
+		if user.TOTPSecret == "" {
+			c.JSON(400, gin.H{"error": "请先获取绑定二维码"})
+			return
+		}
+		if !checkTOTP(user, req.Code) {
+			recordLoginFailure(user.Username, ip)
+			c.JSON(401, gin.H{"error": errBadMFACode.Error()})
+			return
+		}
+		if recoveryCodes, err = enableTOTP(user.ID); err != nil {
+			c.JSON(500, gin.H{"error": "绑定失败"})
+			return
+		}
+	}
+
+	recordLoginSuccess(user.Username)
+	resp, err := issueSession(c, user, device)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "登录失败"})
+		return
+	}
+	if recoveryCodes != nil {
+		resp["recovery_codes"] = recoveryCodes
+	}
+	c.JSON(200, resp)
+}
+
+// LoginMFASetupHandler 强制两步验证的账号在登录过程中获取绑定信息
+func LoginMFASetupHandler(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	c.ShouldBindJSON(&req)
+	user, _, err := parseMFAToken(req.MFAToken)
+	if err != nil {
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "已绑定两步验证"})
+		return
+	}
+	c.JSON(200, startTOTPSetup(user))
+}
+
+// startTOTPSetup 生成待确认的密钥，确认前不会生效
+func startTOTPSetup(user User) gin.H {
+	secret := newTOTPSecret()
+	db.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0})
+	return gin.H{"secret": secret, "uri": provisioningURI(user, secret)}
+}
+
+func enableTOTP(userID uint) ([]string, error) {
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+func SetupTOTPHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var user User
+	db.First(&user, userID)
+	if user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "已绑定两步验证，如需更换请先解绑"})
+		return
+	}
+	c.JSON(200, startTOTPSetup(user))
+}
+
+func EnableTOTPHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req struct {
+		Code string `json:"code"`
+	}
+	c.ShouldBindJSON(&req)
+	var user User
+	db.First(&user, userID)
+	if user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "已绑定两步验证"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(400, gin.H{"error": "请先获取绑定二维码"})
+		return
+	}
+	if !checkTOTP(user, req.Code) {
+		c.JSON(400, gin.H{"error": errBadMFACode.Error()})
+		return
+	}
+	codes, err := enableTOTP(user.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "绑定失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "两步验证已开启，请妥善保存恢复码", "recovery_codes": codes})
+}
+
+// DisableTOTPHandler 关闭两步验证，需要密码加验证码，丢失验证器时可以用恢复码代替验证码
+func DisableTOTPHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	c.ShouldBindJSON(&req)
+	var user User
+	db.First(&user, userID)
+	if mfaRequired(user.Role) {
+		c.JSON(403, gin.H{"error": "当前角色必须开启两步验证"})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "未开启两步验证"})
+		return
+	}
+	// 统一身份认证账号的本地密码是随机生成的，只凭验证码或恢复码确认
+	if !ssoAccount(user.ID) && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		c.JSON(400, gin.H{"error": "密码或验证码错误"})
+		return
+	}
+	var verified bool
+	if req.RecoveryCode != "" {
+		verified = useRecoveryCode(user.ID, req.RecoveryCode)
+	} else {
+		verified = checkTOTP(user, req.Code)
+	}
+	if !verified {
+		c.JSON(400, gin.H{"error": "密码或验证码错误"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": ""}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "操作失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "两步验证已关闭"})
+}
+
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req struct {
+		Code string `json:"code"`
+	}
+	c.ShouldBindJSON(&req)
+	var user User
+	db.First(&user, userID)
+	if !user.TOTPEnabled || !checkTOTP(user, req.Code) {
+		c.JSON(400, gin.H{"error": errBadMFACode.Error()})
+		return
+	}
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "生成失败"})
+		return
+	}
+	c.JSON(200, gin.H{"recovery_codes": codes})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// enableTestTOTP 为用户开启两步验证，返回密钥和恢复码
+func enableTestTOTP(t *testing.T, user User) (string, []string) {
+	t.Helper()
+	secret := newTOTPSecret()
+	db.Model(&user).Update("totp_secret", secret)
+	codes, err := enableTOTP(user.ID)
+	if err != nil {
+		t.Fatalf("开启两步验证失败: %v", err)
+	}
+	return secret, codes
+}
+
+func TestDisableTOTP(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	currentCode := func(secret string) string {
+		code, _ := totpCode(secret, time.Now().Unix()/totpPeriod)
+		return code
+	}
+
+	tests := []struct {
+		name string
+		sso  bool
+		body func(secret string, codes []string) map[string]string
+		want int
+	}{
+		{"本地账号缺少密码", false, func(secret string, codes []string) map[string]string {
+			return map[string]string{"code": currentCode(secret)}
+		}, 400},
+		{"本地账号密码加验证码", false, func(secret string, codes []string) map[string]string {
+			return map[string]string{"password": testPassword, "code": currentCode(secret)}
+		}, 200},
+		{"本地账号密码加恢复码", false, func(secret string, codes []string) map[string]string {
+			return map[string]string{"password": testPassword, "recovery_code": codes[0]}
+		}, 200},
+		{"统一身份认证账号验证码错误", true, func(secret string, codes []string) map[string]string {
+			return map[string]string{"code": "000000"}
+		}, 400},
+		{"统一身份认证账号凭验证码关闭", true, func(secret string, codes []string) map[string]string {
+			return map[string]string{"code": currentCode(secret)}
+		}, 200},
+		{"统一身份认证账号凭恢复码关闭", true, func(secret string, codes []string) map[string]string {
+			return map[string]string{"recovery_code": codes[0]}
+		}, 200},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := createTestUser(t, fmt.Sprintf("user%d", i), "student")
+			if tt.sso {
+				db.Create(&ExternalIdentity{UserID: user.ID, Issuer: "https://idp.example.com", Subject: user.Username})
+			}
+			secret, codes := enableTestTOTP(t, user)
+			w := doRequest(r, http.MethodPost, "/api/v1/user/2fa/disable", loginAs(t, user), tt.body(secret, codes))
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+			var got User
+			db.First(&got, user.ID)
+			if got.TOTPEnabled != (tt.want != 200) {
+				t.Fatalf("两步验证开启状态 = %v", got.TOTPEnabled)
+			}
+		})
+	}
+}
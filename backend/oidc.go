@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// OIDC 统一身份认证登录
+// ===========================
+
+var (
+	// 未配置 OIDC_ISSUER 时不启用
+	OIDC_ISSUER        = ""
+	OIDC_CLIENT_ID     = ""
+	OIDC_CLIENT_SECRET = ""
+	OIDC_REDIRECT_URL  = "" // 默认 APP_BASE_URL + /api/v1/oidc/callback
+	// 首次通过统一身份认证登录时自动创建账号所用的角色
+	OIDC_DEFAULT_ROLE = "student"
+)
+
+const (
+	TokenSSOLogin   = "sso_login"
+	ssoCodeTTL      = time.Minute
+	oidcStateTTL    = 10 * time.Minute
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/api/v1/oidc"
+)
+
+// ExternalIdentity 外部身份与本地账号的绑定，Issuer + Subject 唯一确定一个外部用户
+type ExternalIdentity struct {
+	gorm.Model
+	UserID  uint   `gorm:"index" json:"user_id"`
+	Issuer  string `gorm:"size:191;uniqueIndex:idx_external_subject" json:"issuer"`
+	Subject string `gorm:"size:191;uniqueIndex:idx_external_subject" json:"subject"`
+	Email   string `json:"email"`
+}
+
+var oidcClient struct {
+	sync.Mutex
+	provider *oidc.Provider
+	config   oauth2.Config
+}
+
+var usernameCleaner = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+func initOIDC() {
+	OIDC_ISSUER = strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/")
+	OIDC_CLIENT_ID = os.Getenv("OIDC_CLIENT_ID")
+	OIDC_CLIENT_SECRET = os.Getenv("OIDC_CLIENT_SECRET")
+	OIDC_REDIRECT_URL = os.Getenv("OIDC_REDIRECT_URL")
+	if OIDC_REDIRECT_URL == "" {
+		OIDC_REDIRECT_URL = APP_BASE_URL + "/api/v1/oidc/callback"
+	}
+	if v := os.Getenv("OIDC_DEFAULT_ROLE"); v != "" {
+		switch {
+		case !roleExists(v):
+			log.Printf("⚠️ OIDC_DEFAULT_ROLE=%s 不存在，使用 student", v)
+		case !oidcRoleAllowed(v):
+			// 任何能登录身份提供方的人都会拿到该角色，权限不能超出内置的学生和教师角色
+			log.Printf("⚠️ OIDC_DEFAULT_ROLE=%s 拥有学生和教师以外的权限，不能用于自动创建账号，使用 student", v)
+		default:
+			OIDC_DEFAULT_ROLE = v
+		}
+	}
+}
+
+// oidcRoleAllowed 判断角色的权限是否都在内置学生、教师角色的默认权限之内
+func oidcRoleAllowed(role string) bool {
+	var allowed []string
+	for _, br := range builtinRoles {
+		if br.Name == "student" || br.Name == "teacher" {
+			allowed = append(allowed, br.Permissions...)
+		}
+	}
+	for _, p := range rolePermissions(role) {
+		if !slices.Contains(allowed, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// oidcProvider 首次使用时再做发现，身份提供方晚于本服务启动也不影响
+func oidcProvider(ctx context.Context) (*oidc.Provider, oauth2.Config, error) {
+	oidcClient.Lock()
+	defer oidcClient.Unlock()
+	if oidcClient.provider != nil {
+		return oidcClient.provider, oidcClient.config, nil
+	}
+	if OIDC_ISSUER == "" || OIDC_CLIENT_ID == "" {
+		return nil, oauth2.Config{}, errors.New("未启用统一身份认证")
+	}
+	provider, err := oidc.NewProvider(ctx, OIDC_ISSUER)
+	if err != nil {
+		log.Printf("⚠️ OIDC 发现失败: %v", err)
+		return nil, oauth2.Config{}, errors.New("统一身份认证暂不可用")
+	}
+	oidcClient.provider = provider
+	oidcClient.config = oauth2.Config{
+		ClientID:     OIDC_CLIENT_ID,
+		ClientSecret: OIDC_CLIENT_SECRET,
+		RedirectURL:  OIDC_REDIRECT_URL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	return oidcClient.provider, oidcClient.config, nil
+}
+
+func randomString() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// ssoRedirect 回到前端登录页，成功时带一次性 sso_code，失败时带 sso_error
+func ssoRedirect(c *gin.Context, key, value string) {
+	c.Redirect(302, APP_BASE_URL+"/login?"+url.Values{key: {value}}.Encode())
+}
+
+// OIDCLoginHandler 跳转到身份提供方，state、nonce 和 PKCE 校验值放在签名 Cookie 中
+func OIDCLoginHandler(c *gin.Context) {
+	_, config, err := oidcProvider(c.Request.Context())
+	if err != nil {
+		ssoRedirect(c, "sso_error", err.Error())
+		return
+	}
+	state, nonce, verifier := randomString(), randomString(), oauth2.GenerateVerifier()
+	cookie, err := signJWT(jwt.MapClaims{
+		"purpose":  "oidc_state",
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"device":   c.Query("device"),
+		"exp":      time.Now().Add(oidcStateTTL).Unix(),
+	})
+	if err != nil {
+		ssoRedirect(c, "sso_error", "登录失败")
+		return
+	}
+	secure := strings.HasPrefix(OIDC_REDIRECT_URL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode) // 身份提供方跳回时需要带上
+	c.SetCookie(oidcStateCookie, cookie, int(oidcStateTTL.Seconds()), oidcCookiePath, "", secure, true)
+	c.Redirect(302, config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)))
+}
+
+// OIDCCallbackHandler 身份提供方回调：换取并校验 ID Token，找到或创建本地账号后签发一次性登录码
+func OIDCCallbackHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	provider, config, err := oidcProvider(ctx)
+	if err != nil {
+		ssoRedirect(c, "sso_error", err.Error())
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		ssoRedirect(c, "sso_error", "身份认证被拒绝："+e)
+		return
+	}
+
+	raw, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, "", false, true)
+	claims, err := parseJWT(raw)
+	if err != nil {
+		ssoRedirect(c, "sso_error", "登录已超时，请重试")
+		return
+	}
+	if claims["purpose"] != "oidc_state" || claims["state"] != c.Query("state") {
+		ssoRedirect(c, "sso_error", "登录状态校验失败，请重试")
+		return
+	}
+	verifier, _ := claims["verifier"].(string)
+	device, _ := claims["device"].(string)
+
+	oauthToken, err := config.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Printf("⚠️ OIDC 授权码换取失败: %v", err)
+		ssoRedirect(c, "sso_error", "身份认证失败")
+		return
+	}
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		ssoRedirect(c, "sso_error", "身份认证失败")
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: OIDC_CLIENT_ID}).Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != claims["nonce"] {
+		ssoRedirect(c, "sso_error", "身份认证失败")
+		return
+	}
+	var profile struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	idToken.Claims(&profile)
+
+	user, err := userForIdentity(idToken.Issuer, idToken.Subject, profile.PreferredUsername, profile.Email, profile.EmailVerified)
+	if err != nil {
+		log.Printf("⚠️ OIDC 账号映射失败: %v", err)
+		ssoRedirect(c, "sso_error", "账号创建失败")
+		return
+	}
+	code, err := createUserToken(user, TokenSSOLogin, device, ssoCodeTTL)
+	if err != nil {
+		ssoRedirect(c, "sso_error", "登录失败")
+		return
+	}
+	ssoRedirect(c, "sso_code", code)
+}
+
+// userForIdentity 按外部身份查找本地账号，首次登录时自动创建。
+// 不会按邮箱自动关联已有的本地账号，避免身份提供方上同名邮箱接管他人账号
+func userForIdentity(issuer, subject, preferred, email string, emailVerified bool) (User, error) {
+	var user User
+	var identity ExternalIdentity
+	if err := db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error; err == nil {
+		err = db.First(&user, identity.UserID).Error
+		return user, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(randomString()), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user = User{Username: uniqueUsername(tx, preferred, email), Password: string(hashedPwd), Role: OIDC_DEFAULT_ROLE}
+		if normalized, err := normalizeEmail(email); err == nil && emailVerified {
+			var count int64
+			tx.Model(&User{}).Where("email = ?", normalized).Count(&count)
+			if count == 0 {
+				now := time.Now()
+				user.Email, user.EmailVerifiedAt = &normalized, &now
+			}
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&ExternalIdentity{UserID: user.ID, Issuer: issuer, Subject: subject, Email: email}).Error
+	})
+	return user, err
+}
+
+// ssoAccount 判断账号是否通过统一身份认证创建或绑定，这类账号的本地密码是随机生成的
+func ssoAccount(userID uint) bool {
+	var count int64
+	db.Model(&ExternalIdentity{}).Where("user_id = ?", userID).Count(&count)
+	return count > 0
+}
+
+// uniqueUsername 由外部资料生成可用的用户名，重名时追加随机后缀
+func uniqueUsername(tx *gorm.DB, preferred, email string) string {
+	base := usernameCleaner.ReplaceAllString(preferred, "")
+	if base == "" {
+		local, _, _ := strings.Cut(email, "@")
+		base = usernameCleaner.ReplaceAllString(local, "")
+	}
+	if base == "" || reservedUsername(base) {
+		base = "sso_user"
+	}
+	name := base
+	for i := 0; i < 10; i++ {
+		var count int64
+		tx.Unscoped().Model(&User{}).Where("username = ?", name).Count(&count)
+		if count == 0 {
+			return name
+		}
+		name = fmt.Sprintf("%s_%s", base, randomString()[:4])
+	}
+	return base + "_" + randomString()[:8]
+}
+
+// SSOExchangeHandler 前端用回调拿到的一次性登录码换取令牌，与密码登录走同样的两步验证流程
+func SSOExchangeHandler(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	var user User
+	var device string
+	err := consumeUserToken(req.Code, TokenSSOLogin, func(tx *gorm.DB, t UserToken) error {
+		device = t.Email // 登录码借用 Email 字段记录设备名
+		return tx.First(&user, t.UserID).Error
+	})
+	if err != nil {
+		c.JSON(401, gin.H{"error": "登录已超时，请重试"})
+		return
+	}
+	completeLogin(c, user, device)
+}
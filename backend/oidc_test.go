@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIDP 测试用的身份提供方，提供发现文档、JWKS 和令牌接口
+type fakeIDP struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]jwt.MapClaims // 授权码 -> 换取到的 ID Token 声明
+}
+
+func newFakeIDP(t *testing.T) *fakeIDP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	p := &fakeIDP{key: key, codes: map[string]jwt.MapClaims{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		claims, ok := p.codes[r.Form.Get("code")]
+		delete(p.codes, r.Form.Get("code"))
+		p.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if !ok || r.Form.Get("code_verifier") == "" {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access", "token_type": "Bearer", "expires_in": 3600, "id_token": idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// issue 登记一个授权码，换取时返回带指定声明的 ID Token
+func (p *fakeIDP) issue(code string, claims jwt.MapClaims) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = claims
+}
+
+// oidcLogin 一次登录中测试可以篡改的参数
+type oidcLogin struct {
+	state, nonce string
+	cookie       *http.Cookie
+	claims       jwt.MapClaims
+}
+
+// login 走一遍登录跳转和回调，返回回调最终跳转到前端登录页时的查询参数
+func (p *fakeIDP) login(t *testing.T, r *gin.Engine, claims jwt.MapClaims, tamper func(l *oidcLogin)) url.Values {
+	t.Helper()
+	w := doRequest(r, http.MethodGet, "/api/v1/oidc/login?device=test", "", nil)
+	if w.Code != 302 {
+		t.Fatalf("登录跳转状态码 = %d，响应 %s", w.Code, w.Body.String())
+	}
+	authURL, _ := url.Parse(w.Header().Get("Location"))
+	if authURL.Host != mustParseURL(p.URL).Host || authURL.Query().Get("code_challenge") == "" {
+		t.Fatalf("跳转地址不正确: %s", authURL)
+	}
+	l := &oidcLogin{state: authURL.Query().Get("state"), nonce: authURL.Query().Get("nonce"), claims: jwt.MapClaims{
+		"iss": p.URL,
+		"aud": OIDC_CLIENT_ID,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			l.cookie = c
+		}
+	}
+	for k, v := range claims {
+		l.claims[k] = v
+	}
+	l.claims["nonce"] = l.nonce
+	if tamper != nil {
+		tamper(l)
+	}
+
+	code := randomString()
+	p.issue(code, l.claims)
+	req := newJSONRequest(http.MethodGet, "/api/v1/oidc/callback?"+url.Values{"code": {code}, "state": {l.state}}.Encode(), "", nil)
+	if l.cookie != nil {
+		req.AddCookie(l.cookie)
+	}
+	w = serve(r, req)
+	if w.Code != 302 {
+		t.Fatalf("回调状态码 = %d，响应 %s", w.Code, w.Body.String())
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	return loc.Query()
+}
+
+func mustParseURL(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func setupOIDC(t *testing.T) *fakeIDP {
+	t.Helper()
+	p := newFakeIDP(t)
+	prev := []string{OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL, OIDC_DEFAULT_ROLE}
+	OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET = p.URL, "edu-platform", "secret"
+	OIDC_REDIRECT_URL, OIDC_DEFAULT_ROLE = "http://localhost/api/v1/oidc/callback", "student"
+	oidcClient.provider = nil
+	t.Cleanup(func() {
+		OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL, OIDC_DEFAULT_ROLE = prev[0], prev[1], prev[2], prev[3], prev[4]
+		oidcClient.provider = nil
+	})
+	return p
+}
+
+func TestOIDCCallback(t *testing.T) {
+	setupTestDB(t)
+	p := setupOIDC(t)
+	r := setupRouter()
+	alice := jwt.MapClaims{"sub": "alice-sub", "preferred_username": "alice", "email": "alice@example.com", "email_verified": true}
+
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		tamper  func(l *oidcLogin)
+		wantErr bool
+	}{
+		{"state 不匹配", alice, func(l *oidcLogin) { l.state = "forged" }, true},
+		{"缺少 state Cookie", alice, func(l *oidcLogin) { l.cookie = nil }, true},
+		{"nonce 不匹配", alice, func(l *oidcLogin) { l.claims["nonce"] = "replayed" }, true},
+		{"ID Token 签发给其他应用", alice, func(l *oidcLogin) { l.claims["aud"] = "other-app" }, true},
+		{"ID Token 已过期", alice, func(l *oidcLogin) { l.claims["exp"] = time.Now().Add(-time.Hour).Unix() }, true},
+		{"首次登录自动创建账号", alice, nil, false},
+		{"再次登录映射到同一账号", alice, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := p.login(t, r, tt.claims, tt.tamper)
+			if tt.wantErr {
+				if q.Get("sso_error") == "" || q.Get("sso_code") != "" {
+					t.Fatalf("期望登录失败，实际跳转参数 %v", q)
+				}
+				return
+			}
+			if q.Get("sso_code") == "" {
+				t.Fatalf("期望登录成功，实际跳转参数 %v", q)
+			}
+		})
+	}
+
+	var users []User
+	db.Find(&users)
+	if len(users) != 1 {
+		t.Fatalf("创建了 %d 个账号，期望 1 个", len(users))
+	}
+	user := users[0]
+	if user.Username != "alice" || user.Role != "student" || user.Email == nil || *user.Email != "alice@example.com" {
+		t.Fatalf("账号资料不正确: %s / %s / %v", user.Username, user.Role, user.Email)
+	}
+	var identity ExternalIdentity
+	if err := db.Where("issuer = ? AND subject = ?", p.URL, "alice-sub").First(&identity).Error; err != nil || identity.UserID != user.ID {
+		t.Fatalf("外部身份未绑定到账号: %v", err)
+	}
+}
+
+func TestOIDCDoesNotTakeOverLocalAccount(t *testing.T) {
+	setupTestDB(t)
+	p := setupOIDC(t)
+	r := setupRouter()
+	local := createTestUser(t, "bob", "teacher")
+	email := "bob@example.com"
+	db.Model(&local).Update("email", email)
+
+	// 身份提供方上的同名用户、同一邮箱，也只能得到新的账号
+	q := p.login(t, r, jwt.MapClaims{"sub": "bob-sub", "preferred_username": "bob", "email": email, "email_verified": true}, nil)
+	if q.Get("sso_code") == "" {
+		t.Fatalf("期望登录成功，实际跳转参数 %v", q)
+	}
+	var identity ExternalIdentity
+	db.Where("subject = ?", "bob-sub").First(&identity)
+	var user User
+	db.First(&user, identity.UserID)
+	if user.ID == local.ID || user.Username == "bob" || user.Email != nil || user.Role != "student" {
+		t.Fatalf("外部身份关联到了已有账号或继承了其资料: %d %s %v %s", user.ID, user.Username, user.Email, user.Role)
+	}
+
+	// 登录码只能换取一次令牌
+	w := doRequest(r, http.MethodPost, "/api/v1/oidc/exchange", "", map[string]string{"code": q.Get("sso_code")})
+	if w.Code != 200 || decodeBody(t, w)["user_id"] != float64(user.ID) {
+		t.Fatalf("换取令牌失败: %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(r, http.MethodPost, "/api/v1/oidc/exchange", "", map[string]string{"code": q.Get("sso_code")}); w.Code != 401 {
+		t.Fatalf("登录码重复使用状态码 = %d，期望 401", w.Code)
+	}
+}
+
+func TestOIDCDefaultRole(t *testing.T) {
+	setupTestDB(t)
+	createTestRole(t, "assistant", PermHomeworkGrade, PermQuestionReply)
+	createTestRole(t, "reviewer", PermHomeworkGrade, PermTeacherReview)
+	createTestRole(t, "auditor", PermAuditView)
+	prev := OIDC_DEFAULT_ROLE
+	t.Cleanup(func() { OIDC_DEFAULT_ROLE = prev })
+
+	tests := []struct {
+		role, want string
+	}{
+		{"teacher", "teacher"},
+		{"assistant", "assistant"},
+		{"reviewer", "student"},
+		{"auditor", "student"},
+		{"admin", "student"},
+		{"nobody", "student"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			OIDC_DEFAULT_ROLE = "student"
+			t.Setenv("OIDC_DEFAULT_ROLE", tt.role)
+			initOIDC()
+			if OIDC_DEFAULT_ROLE != tt.want {
+				t.Fatalf("默认角色 = %s，期望 %s", OIDC_DEFAULT_ROLE, tt.want)
+			}
+		})
+	}
+}
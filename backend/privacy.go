@@ -0,0 +1,513 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 个人数据导出与账号注销
+// ===========================
+
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+	ExportExpired    = "expired"
+
+	dataExportTTL           = 7 * 24 * time.Hour // 导出文件保留时间，过期后删除
+	dataExportSweepInterval = time.Hour
+
+	// 注销后的账号改名为 deleted_<id>，该前缀不允许注册
+	deletedUserPrefix = "deleted_"
+
+	// 统一身份认证创建的账号没有可用的本地密码，注销时通过邮件确认码确认身份
+	TokenDeleteAccount = "delete_account"
+	deleteAccountTTL   = 30 * time.Minute
+)
+
+// DataExport 一次个人数据导出任务，压缩包生成后存放在私有 exports 桶中
+type DataExport struct {
+	gorm.Model
+	UserID      uint       `gorm:"index" json:"user_id"`
+	Status      string     `gorm:"size:16;index;default:pending" json:"status"`
+	ObjectName  string     `json:"-"`
+	Size        int64      `json:"size"`
+	Error       string     `json:"error"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+var dataExportQueue = make(chan uint, 100)
+
+// startDataExportWorker 单个后台协程依次生成导出文件，并定期清理过期的导出
+func startDataExportWorker() {
+	// 上次退出时未完成的任务重新排队
+	var ids []uint
+	db.Model(&DataExport{}).Where("status IN ?", []string{ExportPending, ExportProcessing}).Pluck("id", &ids)
+	go func() {
+		for _, id := range ids {
+			dataExportQueue <- id
+		}
+	}()
+	go func() {
+		purgeExpiredExports()
+		ticker := time.NewTicker(dataExportSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case id := <-dataExportQueue:
+				runDataExport(id)
+			case <-ticker.C:
+				purgeExpiredExports()
+			}
+		}
+	}()
+}
+
+func runDataExport(id uint) {
+	var export DataExport
+	if err := db.First(&export, id).Error; err != nil {
+		return
+	}
+	db.Model(&export).Update("status", ExportProcessing)
+	objectName, size, err := buildDataExport(export.UserID)
+	if err != nil {
+		log.Printf("⚠️ 用户 %d 数据导出失败: %v", export.UserID, err)
+		db.Model(&export).Updates(map[string]interface{}{"status": ExportFailed, "error": "导出失败，请稍后重试"})
+		return
+	}
+	now := time.Now()
+	db.Model(&export).Updates(map[string]interface{}{
+		"status":       ExportReady,
+		"object_name":  objectName,
+		"size":         size,
+		"completed_at": now,
+		"expires_at":   now.Add(dataExportTTL),
+	})
+
+	var user User
+	if db.First(&user, export.UserID).Error == nil && user.Email != nil && user.EmailVerifiedAt != nil {
+		go mailer.Send(*user.Email, "【在线教育平台】个人数据导出已完成",
+			fmt.Sprintf("你申请的个人数据已打包完成，请在 %d 天内登录平台个人中心下载：\n%s/profile\n", int(dataExportTTL.Hours()/24), APP_BASE_URL))
+	}
+}
+
+// buildDataExport 把用户的资料、选课、作业、提问和上传的文件打包成 zip 并上传，返回对象名和大小
+func buildDataExport(userID uint) (string, int64, error) {
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp("", "data-export-*.zip")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := zip.NewWriter(tmp)
+	ctx := context.Background()
+	files := exportFiles{zw: zw, ctx: ctx}
+
+	profile := gin.H{
+		"id":                user.ID,
+		"username":          user.Username,
+		"role":              user.Role,
+		"email":             user.Email,
+		"email_verified_at": user.EmailVerifiedAt,
+		"avatar":            user.Avatar,
+		"bio":               user.Bio,
+		"totp_enabled":      user.TOTPEnabled,
+		"created_at":        user.CreatedAt,
+	}
+	if bucket, object, ok := ownObject(user.Avatar); ok {
+		files.add(bucket, object, "files/avatar/"+path.Base(object))
+	}
+
+	var enrollments []Enrollment
+	db.Preload("Course").Where("user_id = ?", user.ID).Find(&enrollments)
+	enrollData := make([]gin.H, 0, len(enrollments))
+	for _, e := range enrollments {
+		enrollData = append(enrollData, gin.H{
+			"course_id":    e.CourseID,
+			"course_title": e.Course.Title,
+			"progress":     e.Progress,
+			"is_finish":    e.IsFinish,
+			"details":      e.Details,
+			"enrolled_at":  e.CreatedAt,
+		})
+	}
+
+	var homeworks []Homework
+	db.Where("student_id = ?", user.ID).Order("id").Find(&homeworks)
+	homeworkData := make([]gin.H, 0, len(homeworks))
+	for _, hw := range homeworks {
+		var revisions []HomeworkRevision
+		db.Preload("Attachments").Where("homework_id = ?", hw.ID).Order("version").Find(&revisions)
+		homeworkData = append(homeworkData, gin.H{"homework": hw, "revisions": revisions})
+	}
+	var attachments []HomeworkAttachment
+	db.Where("uploader_id = ?", user.ID).Find(&attachments)
+	for _, a := range attachments {
+		files.add(BUCKET_HOMEWORK, a.ObjectName, fmt.Sprintf("files/homework/%d_%s", a.ID, path.Base(a.FileName)))
+	}
+
+	var questions []Question
+	db.Where("student_id = ?", user.ID).Order("id").Find(&questions)
+
+	var applications []TeacherApplication
+	db.Where("user_id = ?", user.ID).Order("id").Find(&applications)
+	for _, app := range applications {
+		files.add(BUCKET_CREDENTIALS, app.CredentialObj, fmt.Sprintf("files/credentials/%d_%s", app.ID, path.Base(app.CredentialName)))
+	}
+
+	for name, v := range map[string]interface{}{
+		"profile.json":              profile,
+		"enrollments.json":          enrollData,
+		"homework.json":             homeworkData,
+		"questions.json":            questions,
+		"teacher_applications.json": applications,
+	} {
+		if err := writeZipJSON(zw, name, v); err != nil {
+			return "", 0, err
+		}
+	}
+	if files.err != nil {
+		return "", 0, files.err
+	}
+	if len(files.missing) > 0 {
+		writeZipJSON(zw, "missing_files.json", files.missing)
+	}
+	if err := zw.Close(); err != nil {
+		return "", 0, err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	objectName := fmt.Sprintf("user_%d/%d.zip", user.ID, time.Now().UnixNano())
+	_, err = minioClient.PutObject(ctx, BUCKET_EXPORTS, objectName, tmp, info.Size(),
+		minio.PutObjectOptions{ContentType: "application/zip"})
+	return objectName, info.Size(), err
+}
+
+// exportFiles 把 MinIO 中的文件逐个写入压缩包，源文件已不存在的记录下来而不是让整个导出失败
+type exportFiles struct {
+	zw      *zip.Writer
+	ctx     context.Context
+	missing []string
+	err     error
+}
+
+func (f *exportFiles) add(bucket, object, name string) {
+	if f.err != nil || object == "" {
+		return
+	}
+	if _, err := minioClient.StatObject(f.ctx, bucket, object, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			f.missing = append(f.missing, name)
+			return
+		}
+		f.err = err
+		return
+	}
+	obj, err := minioClient.GetObject(f.ctx, bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		f.err = err
+		return
+	}
+	defer obj.Close()
+	w, err := f.zw.Create(name)
+	if err == nil {
+		_, err = io.Copy(w, obj)
+	}
+	f.err = err
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// ownObject 判断地址是否指向本平台公开桶中的对象（例如头像），返回桶名和对象名
+func ownObject(rawURL string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(rawURL, fmt.Sprintf("http://%s/", MINIO_PUBLIC_ENDPOINT))
+	if !ok {
+		return "", "", false
+	}
+	bucket, object, ok := strings.Cut(rest, "/")
+	if !ok || (bucket != BUCKET_PICTURES && bucket != BUCKET_VIDEOS) {
+		return "", "", false
+	}
+	return bucket, object, true
+}
+
+// purgeExpiredExports 删除过期的导出文件，记录保留用于展示
+func purgeExpiredExports() {
+	var exports []DataExport
+	db.Where("status = ? AND expires_at < ?", ExportReady, time.Now()).Find(&exports)
+	for _, e := range exports {
+		if err := minioClient.RemoveObject(context.Background(), BUCKET_EXPORTS, e.ObjectName, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("⚠️ 删除过期导出 %s 失败: %v", e.ObjectName, err)
+			continue
+		}
+		db.Model(&e).Updates(map[string]interface{}{"status": ExportExpired, "object_name": ""})
+	}
+}
+
+// RequestDataExportHandler 申请导出个人数据，压缩包在后台生成，完成后可在列表中下载
+func RequestDataExportHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var count int64
+	db.Model(&DataExport{}).Where("user_id = ? AND status IN ?", userID, []string{ExportPending, ExportProcessing}).Count(&count)
+	if count > 0 {
+		c.JSON(400, gin.H{"error": "已有导出任务正在处理，请稍后"})
+		return
+	}
+	export := DataExport{UserID: userID, Status: ExportPending}
+	if err := db.Create(&export).Error; err != nil {
+		c.JSON(500, gin.H{"error": "申请失败"})
+		return
+	}
+	go func() { dataExportQueue <- export.ID }()
+	c.JSON(200, gin.H{"message": "已开始导出，完成后可在此下载", "data": export})
+}
+
+func ListDataExportsHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var exports []DataExport
+	db.Where("user_id = ?", userID).Order("id desc").Limit(10).Find(&exports)
+	c.JSON(200, gin.H{"data": exports})
+}
+
+// DataExportURLHandler 为已完成的导出签发临时下载地址
+func DataExportURLHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var export DataExport
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&export).Error; err != nil {
+		c.JSON(404, gin.H{"error": "导出不存在"})
+		return
+	}
+	if export.Status != ExportReady || time.Now().After(*export.ExpiresAt) {
+		c.JSON(400, gin.H{"error": "导出文件尚未生成或已过期"})
+		return
+	}
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf(`attachment; filename="data_export_%d.zip"`, export.ID))
+	u, err := minioPresignClient.PresignedGetObject(context.Background(), BUCKET_EXPORTS, export.ObjectName, attachmentURLTTL, params)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "生成下载地址失败"})
+		return
+	}
+	c.JSON(200, gin.H{"url": u.String(), "expires_in": int(attachmentURLTTL.Seconds())})
+}
+
+// SendAccountDeletionCodeHandler 向已验证的邮箱发送注销确认码，供没有本地密码的统一身份认证账号使用
+func SendAccountDeletionCodeHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "用户不存在"})
+		return
+	}
+	if user.Email == nil || user.EmailVerifiedAt == nil {
+		c.JSON(400, gin.H{"error": "请先绑定并验证邮箱，或开启两步验证"})
+		return
+	}
+	token, err := createUserToken(user, TokenDeleteAccount, *user.Email, deleteAccountTTL)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "发送失败"})
+		return
+	}
+	body := fmt.Sprintf("%s，你好：\n\n你正在注销在线教育平台账号，确认码如下（30分钟内有效）：\n%s\n\n如果这不是你本人的操作，请忽略本邮件并尽快检查账号安全。",
+		user.Username, token)
+	if err := mailer.Send(*user.Email, "【在线教育平台】注销账号确认", body); err != nil {
+		log.Printf("⚠️ 发送注销确认邮件失败: %v", err)
+		c.JSON(500, gin.H{"error": "邮件发送失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "确认码已发送到你的邮箱"})
+}
+
+// lastUserManager 判断 user 是否是最后一个能管理用户的未禁用账号，不论其角色名是什么
+func lastUserManager(user User) bool {
+	if !hasPermission(user.Role, PermUserManage) {
+		return false
+	}
+	var roles []string
+	db.Model(&Role{}).Pluck("name", &roles)
+	managers := slices.DeleteFunc(roles, func(r string) bool { return !hasPermission(r, PermUserManage) })
+	var count int64
+	db.Model(&User{}).Where("role IN ? AND id <> ? AND disabled_at IS NULL", managers, user.ID).Count(&count)
+	return count == 0
+}
+
+// DeleteAccountHandler 用户注销自己的账号，需要再次输入密码，开启两步验证的还需验证码。
+// 统一身份认证创建的账号没有可用的本地密码，改用两步验证码，未开启两步验证时使用邮件确认码
+func DeleteAccountHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+		Token    string `json:"token"` // 邮件确认码
+	}
+	c.ShouldBindJSON(&req)
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "用户不存在"})
+		return
+	}
+	sso := ssoAccount(user.ID)
+	byEmail := sso && !user.TOTPEnabled
+	if !sso && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		c.JSON(400, gin.H{"error": "密码错误"})
+		return
+	}
+	if byEmail && req.Token == "" {
+		c.JSON(400, gin.H{"error": "请输入邮件中的确认码"})
+		return
+	}
+	if user.TOTPEnabled && !checkTOTP(user, req.Code) {
+		c.JSON(400, gin.H{"error": errBadMFACode.Error()})
+		return
+	}
+	// 保证平台上始终有人能管理用户
+	if lastUserManager(user) {
+		c.JSON(400, gin.H{"error": "你是最后一位管理员，无法注销"})
+		return
+	}
+	if byEmail {
+		err := consumeUserToken(req.Token, TokenDeleteAccount, func(tx *gorm.DB, t UserToken) error {
+			if t.UserID != user.ID {
+				return errInvalidToken
+			}
+			return nil
+		})
+		if err != nil {
+			c.JSON(400, gin.H{"error": "确认码无效或已过期"})
+			return
+		}
+	}
+	if err := eraseUser(user, nil); err != nil {
+		c.JSON(500, gin.H{"error": "注销失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "账号已注销"})
+}
+
+// eraseUser 清除账号的个人信息。作业和提问仍是教师需要的教学记录，不删除，
+// 而是保留指向已匿名化账号的引用，作者显示为 deleted_<id>。audit 不为空时在同一事务中写入审计日志
+func eraseUser(user User, audit func(tx *gorm.DB) error) error {
+	type storedObject struct{ bucket, name string }
+	var objects []storedObject
+	// 头像地址可能被设成了课程封面，仍被引用时不删除
+	if bucket, object, ok := ownObject(user.Avatar); ok {
+		var count int64
+		db.Model(&Course{}).Where("cover_image = ?", user.Avatar).Count(&count)
+		if count == 0 {
+			objects = append(objects, storedObject{bucket, object})
+		}
+	}
+	var applications []TeacherApplication
+	db.Unscoped().Where("user_id = ?", user.ID).Find(&applications)
+	for _, app := range applications {
+		objects = append(objects, storedObject{BUCKET_CREDENTIALS, app.CredentialObj})
+	}
+	var exports []DataExport
+	db.Unscoped().Where("user_id = ? AND object_name <> ''", user.ID).Find(&exports)
+	for _, e := range exports {
+		objects = append(objects, storedObject{BUCKET_EXPORTS, e.ObjectName})
+	}
+
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"username":             fmt.Sprintf("%s%d", deletedUserPrefix, user.ID),
+			"password":             "",
+			"email":                nil,
+			"email_verified_at":    nil,
+			"avatar":               "",
+			"bio":                  "",
+			"totp_secret":          "",
+			"totp_enabled":         false,
+			"must_change_password": false,
+			"disabled_at":          now,
+			"disabled_reason":      "账号已注销",
+			"token_version":        gorm.Expr("token_version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		// API Key 保留记录供审计日志引用，只吊销并清除使用 IP
+		if err := tx.Model(&APIKey{}).Where("user_id = ?", user.ID).
+			Updates(map[string]interface{}{"revoked_at": gorm.Expr("COALESCE(revoked_at, ?)", now), "last_used_ip": ""}).Error; err != nil {
+			return err
+		}
+		// 模拟登录期间的请求记录保留，但清除 IP
+		if err := tx.Model(&ImpersonationRequest{}).
+			Where("impersonation_id IN (?)", tx.Model(&Impersonation{}).Select("id").Where("user_id = ? OR actor_id = ?", user.ID, user.ID)).
+			Update("ip", "").Error; err != nil {
+			return err
+		}
+		// 外部身份直接删除，之后用同一身份登录会创建新账号
+		for _, model := range []interface{}{&Session{}, &RecoveryCode{}, &UserToken{}, &ExternalIdentity{}, &TeacherApplication{}, &DataExport{}} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&User{}, user.ID).Error; err != nil {
+			return err
+		}
+		if audit != nil {
+			return audit(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if o.name == "" {
+			continue
+		}
+		if err := minioClient.RemoveObject(context.Background(), o.bucket, o.name, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("⚠️ 删除用户 %d 的文件 %s/%s 失败: %v", user.ID, o.bucket, o.name, err)
+		}
+	}
+	return nil
+}
+
+// reservedUsername 系统保留的用户名不能用于注册或改名
+func reservedUsername(name string) bool {
+	return name == "admin" || strings.HasPrefix(name, deletedUserPrefix)
+}
+
+// withDeleted 预加载作者时包含已注销的账号
+func withDeleted(tx *gorm.DB) *gorm.DB {
+	return tx.Unscoped()
+}
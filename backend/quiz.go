@@ -0,0 +1,723 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ===========================
+// 题库与自动批改测验
+// ===========================
+
+// 题型
+const (
+	QuestionSingle    = "single"     // 单选
+	QuestionMultiple  = "multiple"   // 多选
+	QuestionTrueFalse = "true_false" // 判断
+	QuestionFillBlank = "fill_blank" // 填空
+)
+
+// JSONStrings 以 JSON 数组形式存入 text 列的字符串切片
+type JSONStrings []string
+
+func (j JSONStrings) Value() (driver.Value, error) {
+	if j == nil {
+		return "[]", nil
+	}
+	raw, err := json.Marshal([]string(j))
+	return string(raw), err
+}
+
+func (j *JSONStrings) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+		return nil
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("无法解析 JSONStrings: %T", value)
+	}
+	if len(raw) == 0 {
+		*j = nil
+		return nil
+	}
+	return json.Unmarshal(raw, (*[]string)(j))
+}
+
+// BankQuestion 课程题库中的题目。
+// 选择题的 Options 依次对应 A、B、C…，Answer 存放正确选项的字母；
+// 判断题 Answer 为 "true" 或 "false"；填空题 Answer 为所有可接受的答案。
+type BankQuestion struct {
+	gorm.Model
+	CourseID    uint        `gorm:"index" json:"course_id"`
+	TeacherID   uint        `json:"teacher_id"`
+	Type        string      `json:"type"`
+	Stem        string      `json:"stem" gorm:"type:text"`
+	Options     JSONStrings `json:"options" gorm:"type:text"`
+	Answer      JSONStrings `json:"answer" gorm:"type:text"`
+	Points      int         `json:"points" gorm:"default:1"`
+	Explanation string      `json:"explanation" gorm:"type:text"`
+}
+
+type Quiz struct {
+	gorm.Model
+	CourseID    uint           `gorm:"index" json:"course_id"`
+	TeacherID   uint           `json:"teacher_id"`
+	Title       string         `json:"title"`
+	Description string         `json:"description" gorm:"type:text"`
+	DrawCount   int            `json:"draw_count"` // 每次从题目池随机抽取的题数，0 表示全部
+	Shuffle     bool           `json:"shuffle"`    // 是否打乱题目顺序
+	PassPercent float64        `json:"pass_percent" gorm:"default:60"`
+	TimeLimit   int            `json:"time_limit"`   // 考试时长（分钟），0 表示不限时的普通测验
+	MaxAttempts int            `json:"max_attempts"` // 最多可交卷次数，0 表示不限
+	Questions   []BankQuestion `gorm:"many2many:quiz_questions" json:"questions,omitempty"`
+}
+
+// QuizAttempt 学生的一次作答，QuestionIDs 固定了本次抽到的题目及顺序
+type QuizAttempt struct {
+	gorm.Model
+	QuizID      uint        `gorm:"index" json:"quiz_id"`
+	StudentID   uint        `gorm:"index" json:"student_id"`
+	QuestionIDs JSONStrings `json:"question_ids" gorm:"type:text"`
+	Answers     string      `json:"answers" gorm:"type:text"` // map[题目ID][]string 的 JSON
+	Score       int         `json:"score"`
+	MaxScore    int         `json:"max_score"`
+	Deadline    *time.Time  `json:"deadline" gorm:"index"` // 限时考试的截止时间，以服务端为准
+	SubmittedAt *time.Time  `json:"submitted_at"`
+	AutoSubmit  bool        `json:"auto_submit"` // 超时后由服务端自动交卷
+	// AutoSubmitFailedAt 后台自动交卷失败的时间，之后的扫描跳过该作答，需人工处理
+	AutoSubmitFailedAt *time.Time `json:"auto_submit_failed_at"`
+}
+
+type BankQuestionReq struct {
+	Type        string   `json:"type"`
+	Stem        string   `json:"stem"`
+	Options     []string `json:"options"`
+	Answer      []string `json:"answer"`
+	Points      int      `json:"points"`
+	Explanation string   `json:"explanation"`
+}
+
+type QuizReq struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	QuestionIDs []uint  `json:"question_ids"`
+	DrawCount   int     `json:"draw_count"`
+	Shuffle     bool    `json:"shuffle"`
+	PassPercent float64 `json:"pass_percent"`
+	TimeLimit   int     `json:"time_limit"`
+	MaxAttempts int     `json:"max_attempts"`
+}
+
+// QuizUpdateReq 修改测验时只更新请求中出现的字段
+type QuizUpdateReq struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	QuestionIDs []uint  `json:"question_ids"`
+	DrawCount   *int    `json:"draw_count"`
+	Shuffle     *bool   `json:"shuffle"`
+	PassPercent float64 `json:"pass_percent"`
+	TimeLimit   *int    `json:"time_limit"`
+	MaxAttempts *int    `json:"max_attempts"`
+}
+
+// QuestionView 发给学生的题目，不含答案
+type QuestionView struct {
+	ID      uint     `json:"id"`
+	Type    string   `json:"type"`
+	Stem    string   `json:"stem"`
+	Options []string `json:"options"`
+	Points  int      `json:"points"`
+}
+
+// QuestionResult 交卷后返回的逐题批改结果
+type QuestionResult struct {
+	ID          uint     `json:"id"`
+	Correct     bool     `json:"correct"`
+	Points      int      `json:"points"`
+	Given       []string `json:"given"`
+	Answer      []string `json:"answer"`
+	Explanation string   `json:"explanation"`
+}
+
+func normalizeAnswer(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// validate 校验题目结构并规范化答案
+func (req *BankQuestionReq) validate() error {
+	if strings.TrimSpace(req.Stem) == "" {
+		return errors.New("题干不能为空")
+	}
+	if req.Points <= 0 {
+		req.Points = 1
+	}
+	switch req.Type {
+	case QuestionSingle, QuestionMultiple:
+		if len(req.Options) < 2 || len(req.Options) > 26 {
+			return errors.New("选择题需要 2-26 个选项")
+		}
+		letters := make([]string, 0, len(req.Answer))
+		for _, a := range req.Answer {
+			a = strings.ToUpper(strings.TrimSpace(a))
+			if len(a) != 1 || a[0] < 'A' || int(a[0]-'A') >= len(req.Options) {
+				return fmt.Errorf("答案 %q 不是有效选项", a)
+			}
+			if !slices.Contains(letters, a) {
+				letters = append(letters, a)
+			}
+		}
+		slices.Sort(letters)
+		if len(letters) == 0 || (req.Type == QuestionSingle && len(letters) != 1) {
+			return errors.New("请设置正确的答案")
+		}
+		req.Answer = letters
+	case QuestionTrueFalse:
+		if len(req.Answer) != 1 || (normalizeAnswer(req.Answer[0]) != "true" && normalizeAnswer(req.Answer[0]) != "false") {
+			return errors.New("判断题答案必须为 true 或 false")
+		}
+		req.Options = nil
+		req.Answer = []string{normalizeAnswer(req.Answer[0])}
+	case QuestionFillBlank:
+		accepted := make([]string, 0, len(req.Answer))
+		for _, a := range req.Answer {
+			if strings.TrimSpace(a) != "" {
+				accepted = append(accepted, strings.TrimSpace(a))
+			}
+		}
+		if len(accepted) == 0 {
+			return errors.New("填空题至少需要一个参考答案")
+		}
+		req.Options = nil
+		req.Answer = accepted
+	default:
+		return errors.New("未知的题型")
+	}
+	return nil
+}
+
+// gradeQuestion 判断一道题的作答是否正确；多选题需完全一致才得分
+func gradeQuestion(q BankQuestion, given []string) bool {
+	switch q.Type {
+	case QuestionSingle, QuestionMultiple:
+		picked := make([]string, 0, len(given))
+		for _, g := range given {
+			g = strings.ToUpper(strings.TrimSpace(g))
+			if !slices.Contains(picked, g) {
+				picked = append(picked, g)
+			}
+		}
+		slices.Sort(picked)
+		return slices.Equal(picked, q.Answer)
+	case QuestionTrueFalse:
+		return len(given) == 1 && normalizeAnswer(given[0]) == q.Answer[0]
+	case QuestionFillBlank:
+		if len(given) != 1 {
+			return false
+		}
+		for _, a := range q.Answer {
+			if normalizeAnswer(a) == normalizeAnswer(given[0]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toQuestionView(q BankQuestion) QuestionView {
+	options := []string(q.Options)
+	if options == nil {
+		options = []string{}
+	}
+	return QuestionView{ID: q.ID, Type: q.Type, Stem: q.Stem, Options: options, Points: q.Points}
+}
+
+// drawQuestions 按测验配置抽题并决定顺序
+func drawQuestions(quiz Quiz) []BankQuestion {
+	pool := slices.Clone(quiz.Questions)
+	if quiz.DrawCount > 0 && quiz.DrawCount < len(pool) {
+		rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+		pool = pool[:quiz.DrawCount]
+		if !quiz.Shuffle {
+			slices.SortFunc(pool, func(a, b BankQuestion) int { return int(a.ID) - int(b.ID) })
+		}
+	} else if quiz.Shuffle {
+		rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	}
+	return pool
+}
+
+// attemptQuestions 按作答记录中固定的顺序加载题目
+func attemptQuestions(attempt QuizAttempt) []BankQuestion {
+	var questions []BankQuestion
+	db.Unscoped().Where("id IN ?", []string(attempt.QuestionIDs)).Find(&questions)
+	byID := make(map[string]BankQuestion, len(questions))
+	for _, q := range questions {
+		byID[fmt.Sprint(q.ID)] = q
+	}
+	ordered := make([]BankQuestion, 0, len(questions))
+	for _, id := range attempt.QuestionIDs {
+		if q, ok := byID[id]; ok {
+			ordered = append(ordered, q)
+		}
+	}
+	return ordered
+}
+
+// gradeAttempt 批改一次作答，返回得分、满分和逐题结果
+func gradeAttempt(questions []BankQuestion, answers map[string][]string) (int, int, []QuestionResult) {
+	score, maxScore := 0, 0
+	results := make([]QuestionResult, 0, len(questions))
+	for _, q := range questions {
+		given := answers[fmt.Sprint(q.ID)]
+		correct := gradeQuestion(q, given)
+		maxScore += q.Points
+		points := 0
+		if correct {
+			points = q.Points
+			score += q.Points
+		}
+		results = append(results, QuestionResult{
+			ID:          q.ID,
+			Correct:     correct,
+			Points:      points,
+			Given:       given,
+			Answer:      q.Answer,
+			Explanation: q.Explanation,
+		})
+	}
+	return score, maxScore, results
+}
+
+// recordQuizScore 把测验得分率写入选课进度，只保留最高分
+func recordQuizScore(tx *gorm.DB, studentID uint, quiz Quiz, score, maxScore int) (Enrollment, error) {
+	percent := 0.0
+	if maxScore > 0 {
+		percent = math.Round(float64(score)*10000/float64(maxScore)) / 100
+	}
+	return updateProgress(tx, studentID, quiz.CourseID, func(details *ProgressDetails, _ []uint) error {
+		if details.Quizzes == nil {
+			details.Quizzes = map[uint]float64{}
+		}
+		if best, ok := details.Quizzes[quiz.ID]; !ok || percent > best {
+			details.Quizzes[quiz.ID] = percent
+		}
+		return nil
+	})
+}
+
+func loadBankQuestion(c *gin.Context, course Course) (BankQuestion, bool) {
+	var q BankQuestion
+	qid, ok := paramID(c, "qid")
+	if !ok {
+		return q, false
+	}
+	if err := db.Where("course_id = ?", course.ID).First(&q, qid).Error; err != nil {
+		c.JSON(404, gin.H{"error": "题目不存在"})
+		return q, false
+	}
+	return q, true
+}
+
+func loadQuiz(c *gin.Context, course Course) (Quiz, bool) {
+	var quiz Quiz
+	qid, ok := paramID(c, "qid")
+	if !ok {
+		return quiz, false
+	}
+	if err := db.Where("course_id = ?", course.ID).First(&quiz, qid).Error; err != nil {
+		c.JSON(404, gin.H{"error": "测验不存在"})
+		return quiz, false
+	}
+	return quiz, true
+}
+
+// resolveQuizQuestions 校验题目都属于本课程题库
+func resolveQuizQuestions(course Course, ids []uint) ([]BankQuestion, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("请至少选择一道题目")
+	}
+	var questions []BankQuestion
+	db.Where("course_id = ? AND id IN ?", course.ID, ids).Find(&questions)
+	if len(questions) != len(slices.Compact(slices.Sorted(slices.Values(ids)))) {
+		return nil, errors.New("题目不存在或不属于本课程")
+	}
+	return questions, nil
+}
+
+func ListBankQuestionsHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	var questions []BankQuestion
+	tx := db.Where("course_id = ?", course.ID)
+	if t := c.Query("type"); t != "" {
+		tx = tx.Where("type = ?", t)
+	}
+	tx.Order("id asc").Find(&questions)
+	c.JSON(200, gin.H{"data": questions})
+}
+
+func CreateBankQuestionHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	var req BankQuestionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	q := BankQuestion{
+		CourseID:    course.ID,
+		TeacherID:   course.TeacherID,
+		Type:        req.Type,
+		Stem:        req.Stem,
+		Options:     req.Options,
+		Answer:      req.Answer,
+		Points:      req.Points,
+		Explanation: req.Explanation,
+	}
+	db.Create(&q)
+	c.JSON(200, gin.H{"message": "添加成功", "data": q})
+}
+
+func UpdateBankQuestionHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	q, ok := loadBankQuestion(c, course)
+	if !ok {
+		return
+	}
+	var req BankQuestionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	db.Model(&q).Updates(map[string]interface{}{
+		"type":        req.Type,
+		"stem":        req.Stem,
+		"options":     JSONStrings(req.Options),
+		"answer":      JSONStrings(req.Answer),
+		"points":      req.Points,
+		"explanation": req.Explanation,
+	})
+	c.JSON(200, gin.H{"message": "更新成功"})
+}
+
+func DeleteBankQuestionHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	q, ok := loadBankQuestion(c, course)
+	if !ok {
+		return
+	}
+	// 已被测验引用的题目软删除后仍可用于展示历史作答
+	db.Exec("DELETE FROM quiz_questions WHERE bank_question_id = ?", q.ID)
+	db.Delete(&q)
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
+
+func ListQuizzesHandler(c *gin.Context) {
+	var quizzes []Quiz
+	db.Where("course_id = ?", c.Param("id")).Order("id asc").Find(&quizzes)
+	c.JSON(200, gin.H{"data": quizzes})
+}
+
+func CreateQuizHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	var req QuizReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Title == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	questions, err := resolveQuizQuestions(course, req.QuestionIDs)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.PassPercent <= 0 || req.PassPercent > 100 {
+		req.PassPercent = 60
+	}
+	quiz := Quiz{
+		CourseID:    course.ID,
+		TeacherID:   course.TeacherID,
+		Title:       req.Title,
+		Description: req.Description,
+		DrawCount:   max(req.DrawCount, 0),
+		Shuffle:     req.Shuffle,
+		PassPercent: req.PassPercent,
+		TimeLimit:   max(req.TimeLimit, 0),
+		MaxAttempts: max(req.MaxAttempts, 0),
+		Questions:   questions,
+	}
+	if err := db.Omit("Questions.*").Create(&quiz).Error; err != nil {
+		c.JSON(500, gin.H{"error": "创建失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "创建成功", "data": quiz})
+}
+
+func UpdateQuizHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	quiz, ok := loadQuiz(c, course)
+	if !ok {
+		return
+	}
+	var req QuizUpdateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	updates := map[string]interface{}{}
+	if req.Shuffle != nil {
+		updates["shuffle"] = *req.Shuffle
+	}
+	if req.DrawCount != nil {
+		updates["draw_count"] = max(*req.DrawCount, 0)
+	}
+	if req.TimeLimit != nil {
+		updates["time_limit"] = max(*req.TimeLimit, 0)
+	}
+	if req.MaxAttempts != nil {
+		updates["max_attempts"] = max(*req.MaxAttempts, 0)
+	}
+	if req.Title != "" {
+		updates["title"] = req.Title
+	}
+	if req.Description != "" {
+		updates["description"] = req.Description
+	}
+	if req.PassPercent > 0 && req.PassPercent <= 100 {
+		updates["pass_percent"] = req.PassPercent
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(&quiz).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if req.QuestionIDs == nil {
+			return nil
+		}
+		questions, err := resolveQuizQuestions(course, req.QuestionIDs)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&quiz).Omit("Questions.*").Association("Questions").Replace(questions)
+	})
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "更新成功"})
+}
+
+func DeleteQuizHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	quiz, ok := loadQuiz(c, course)
+	if !ok {
+		return
+	}
+	db.Delete(&quiz)
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
+
+var (
+	errMaxAttempts     = errors.New("已达到最大作答次数")
+	errNoQuizQuestions = errors.New("测验暂无题目")
+)
+
+// StartQuizHandler 开始作答：有未交卷的作答时直接返回，否则抽题生成新的作答。
+// 限时考试在此刻开始计时，截止时间写入数据库，重启后依然有效
+func StartQuizHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var quiz Quiz
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.Preload("Questions").First(&quiz, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "测验不存在"})
+		return
+	}
+	var count int64
+	db.Model(&Enrollment{}).Where("user_id = ? AND course_id = ?", userID, quiz.CourseID).Count(&count)
+	if count == 0 {
+		c.JSON(403, gin.H{"error": "请先加入课程"})
+		return
+	}
+
+	var attempt QuizAttempt
+	err := db.Where("quiz_id = ? AND student_id = ? AND submitted_at IS NULL", quiz.ID, userID).First(&attempt).Error
+	if err == nil && attempt.expired(time.Now()) {
+		// 超时未交卷的作答先按已保存的答案交卷，再视次数限制决定是否开新卷
+		if _, err := autoSubmitAttempt(attempt); err != nil {
+			c.JSON(500, gin.H{"error": "交卷失败"})
+			return
+		}
+		err = gorm.ErrRecordNotFound
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// 锁住选课记录，使同一学生并发开始作答时次数检查和创建串行执行
+			var enroll Enrollment
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("user_id = ? AND course_id = ?", userID, quiz.CourseID).First(&enroll).Error; err != nil {
+				return err
+			}
+			// 加锁后重新检查，并发的请求可能已经开始了作答
+			if err := tx.Where("quiz_id = ? AND student_id = ? AND submitted_at IS NULL", quiz.ID, userID).First(&attempt).Error; err == nil {
+				return nil
+			}
+			if quiz.MaxAttempts > 0 {
+				var used int64
+				tx.Model(&QuizAttempt{}).Where("quiz_id = ? AND student_id = ?", quiz.ID, userID).Count(&used)
+				if used >= int64(quiz.MaxAttempts) {
+					return errMaxAttempts
+				}
+			}
+			drawn := drawQuestions(quiz)
+			if len(drawn) == 0 {
+				return errNoQuizQuestions
+			}
+			ids := make(JSONStrings, 0, len(drawn))
+			for _, q := range drawn {
+				ids = append(ids, fmt.Sprint(q.ID))
+			}
+			attempt = QuizAttempt{QuizID: quiz.ID, StudentID: userID, QuestionIDs: ids, Answers: "{}"}
+			if quiz.TimeLimit > 0 {
+				deadline := time.Now().Add(time.Duration(quiz.TimeLimit) * time.Minute)
+				attempt.Deadline = &deadline
+			}
+			return tx.Create(&attempt).Error
+		})
+	}
+	if errors.Is(err, errMaxAttempts) || errors.Is(err, errNoQuizQuestions) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "开始测验失败"})
+		return
+	}
+	c.JSON(200, attemptState(attempt))
+}
+
+// SubmitQuizAttemptHandler 交卷并自动批改，得分计入选课进度。
+// 考试超时后提交的答案不再采纳，按最后一次自动保存的答案交卷
+func SubmitQuizAttemptHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req struct {
+		Answers map[string][]string `json:"answers"` // 题目ID -> 所选选项或填写的答案
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	var attempt QuizAttempt
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.Where("student_id = ?", userID).First(&attempt, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "作答记录不存在"})
+		return
+	}
+	if attempt.SubmittedAt != nil {
+		c.JSON(400, gin.H{"error": "已交卷"})
+		return
+	}
+
+	answers, expired := req.Answers, attempt.expired(time.Now())
+	if expired {
+		answers = attempt.savedAnswers()
+	}
+	result, err := finalizeAttempt(attempt, answers, expired)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "交卷失败: " + err.Error()})
+		return
+	}
+	result["expired"] = expired
+	c.JSON(200, result)
+}
+
+var errAttemptSubmitted = errors.New("已交卷")
+
+// finalizeAttempt 批改作答并写入成绩和选课进度；重复交卷返回 errAttemptSubmitted
+func finalizeAttempt(attempt QuizAttempt, answers map[string][]string, auto bool) (gin.H, error) {
+	var quiz Quiz
+	if err := db.Unscoped().First(&quiz, attempt.QuizID).Error; err != nil {
+		return nil, err
+	}
+	score, maxScore, results := gradeAttempt(attemptQuestions(attempt), answers)
+	raw, _ := json.Marshal(answers)
+	now := time.Now()
+	var enroll Enrollment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 条件更新防止并发重复交卷（包括与后台超时交卷的竞争）
+		res := tx.Model(&QuizAttempt{}).Where("id = ? AND submitted_at IS NULL", attempt.ID).Updates(map[string]interface{}{
+			"answers":      string(raw),
+			"score":        score,
+			"max_score":    maxScore,
+			"submitted_at": &now,
+			"auto_submit":  auto,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errAttemptSubmitted
+		}
+		var err error
+		enroll, err = recordQuizScore(tx, attempt.StudentID, quiz, score, maxScore)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"score":     score,
+		"max_score": maxScore,
+		"passed":    maxScore > 0 && float64(score)*100 >= quiz.PassPercent*float64(maxScore),
+		"results":   results,
+		"progress":  enroll.Progress,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateQuizPartial(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	teacher := createTestUser(t, "teacher", "teacher")
+	token := loginAs(t, teacher)
+	course := createTestCourse(t, teacher)
+	original := Quiz{CourseID: course.ID, TeacherID: teacher.ID, Title: "期中考试", DrawCount: 5,
+		Shuffle: true, PassPercent: 60, TimeLimit: 30, MaxAttempts: 3}
+
+	tests := []struct {
+		name string
+		body map[string]interface{}
+		want func(q *Quiz)
+	}{
+		{"只改标题", map[string]interface{}{"title": "期中考试（补）"}, func(q *Quiz) { q.Title = "期中考试（补）" }},
+		{"关闭乱序", map[string]interface{}{"shuffle": false}, func(q *Quiz) { q.Shuffle = false }},
+		{"取消限时", map[string]interface{}{"time_limit": 0}, func(q *Quiz) { q.TimeLimit = 0 }},
+		{"不限次数", map[string]interface{}{"max_attempts": 0}, func(q *Quiz) { q.MaxAttempts = 0 }},
+		{"抽全部题目", map[string]interface{}{"draw_count": 0}, func(q *Quiz) { q.DrawCount = 0 }},
+		{"及格线超出范围时忽略", map[string]interface{}{"pass_percent": 120}, func(q *Quiz) {}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quiz := original
+			db.Create(&quiz)
+			w := doRequest(r, http.MethodPut, fmt.Sprintf("/api/v1/courses/%d/quizzes/%d", course.ID, quiz.ID), token, tt.body)
+			if w.Code != 200 {
+				t.Fatalf("状态码 = %d，响应 %s", w.Code, w.Body.String())
+			}
+			want := original
+			tt.want(&want)
+			var got Quiz
+			db.First(&got, quiz.ID)
+			if got.Title != want.Title || got.DrawCount != want.DrawCount || got.Shuffle != want.Shuffle ||
+				got.PassPercent != want.PassPercent || got.TimeLimit != want.TimeLimit || got.MaxAttempts != want.MaxAttempts {
+				t.Errorf("更新后 = %+v，期望 %+v", quizSettings(got), quizSettings(want))
+			}
+		})
+	}
+}
+
+func quizSettings(q Quiz) []interface{} {
+	return []interface{}{q.Title, q.DrawCount, q.Shuffle, q.PassPercent, q.TimeLimit, q.MaxAttempts}
+}
+
+func TestExpiredAttemptAutoSubmitFailure(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	student := createTestUser(t, "student", "student")
+	token := loginAs(t, student)
+	deadline := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name, method, path string
+		body               interface{}
+	}{
+		{"查询作答", http.MethodGet, "/api/v1/quiz-attempts/%d", nil},
+		{"保存答案", http.MethodPut, "/api/v1/quiz-attempts/%d/answers", map[string]interface{}{"answers": map[string][]string{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 测验已不存在，自动交卷必然失败
+			attempt := QuizAttempt{QuizID: 999, StudentID: student.ID, Deadline: &deadline}
+			db.Create(&attempt)
+			w := doRequest(r, tt.method, fmt.Sprintf(tt.path, attempt.ID), token, tt.body)
+			if w.Code != 500 {
+				t.Fatalf("状态码 = %d，期望 500，响应 %s", w.Code, w.Body.String())
+			}
+			var got QuizAttempt
+			db.First(&got, attempt.ID)
+			if got.SubmittedAt != nil || got.AutoSubmitFailedAt == nil {
+				t.Fatal("自动交卷失败后没有标记作答")
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ===========================
+// 角色与权限
+// ===========================
+
+// 权限点由代码定义，角色只是权限的组合，管理员可以在后台自定义角色
+const (
+	PermCourseCreate    = "course.create"     // 创建课程
+	PermCoursePublish   = "course.publish"    // 创建的课程无需审核直接上线
+	PermCourseAudit     = "course.audit"      // 审核课程
+	PermCourseManageAny = "course.manage_any" // 管理任意课程（不限于自己授课的）
+	PermHomeworkGrade   = "homework.grade"    // 批改作业、查看教学看板
+	PermQuestionReply   = "question.reply"    // 回复学生提问
+	PermStatsView       = "stats.view"        // 查看平台统计
+	PermUserManage      = "user.manage"       // 管理用户、会话和角色分配
+	PermRoleManage      = "role.manage"       // 管理角色定义
+	PermTeacherReview   = "teacher.review"    // 审核教师申请
+	PermUserImpersonate = "user.impersonate"  // 以其他用户身份登录排查问题
+	PermAuditView       = "audit.view"        // 查看和导出审计日志
+)
+
+// Permission 权限点，启动时根据 permissionCatalog 同步
+type Permission struct {
+	ID   uint   `gorm:"primarykey" json:"id"`
+	Code string `gorm:"size:64;uniqueIndex" json:"code"`
+	Name string `json:"name"`
+}
+
+// Role 角色，User.Role 保存角色名
+type Role struct {
+	gorm.Model
+	Name        string       `gorm:"size:64;uniqueIndex" json:"name"`
+	DisplayName string       `json:"display_name"`
+	Builtin     bool         `json:"builtin"` // 内置角色不能删除
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions"`
+}
+
+var permissionCatalog = []Permission{
+	{Code: PermCourseCreate, Name: "创建课程"},
+	{Code: PermCoursePublish, Name: "免审核发布课程"},
+	{Code: PermCourseAudit, Name: "审核课程"},
+	{Code: PermCourseManageAny, Name: "管理所有课程"},
+	{Code: PermHomeworkGrade, Name: "批改作业"},
+	{Code: PermQuestionReply, Name: "回复提问"},
+	{Code: PermStatsView, Name: "查看平台统计"},
+	{Code: PermUserManage, Name: "管理用户"},
+	{Code: PermRoleManage, Name: "管理角色"},
+	{Code: PermTeacherReview, Name: "审核教师申请"},
+	{Code: PermUserImpersonate, Name: "模拟用户登录"},
+	{Code: PermAuditView, Name: "查看审计日志"},
+}
+
+// 内置角色首次启动时创建，之后可以在后台调整（admin 除外）
+var builtinRoles = []struct {
+	Name        string
+	DisplayName string
+	Permissions []string
+}{
+	{"student", "学生", nil},
+	{"teacher", "教师", []string{PermCourseCreate, PermHomeworkGrade, PermQuestionReply}},
+	{"admin", "管理员", nil}, // 始终拥有全部权限
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// 角色权限缓存，本机修改角色时立即失效，其他实例最多延迟 permissionCacheTTL
+const permissionCacheTTL = time.Minute
+
+var permissionCache struct {
+	sync.RWMutex
+	roles    map[string][]string
+	loadedAt time.Time
+}
+
+// migrateRoles 同步权限点并补齐内置角色，admin 角色每次启动都会拿到全部权限
+func migrateRoles() {
+	for _, p := range permissionCatalog {
+		db.Where(Permission{Code: p.Code}).Assign(Permission{Name: p.Name}).FirstOrCreate(&p)
+	}
+	var all []Permission
+	db.Find(&all)
+	for _, br := range builtinRoles {
+		var role Role
+		err := db.Where("name = ?", br.Name).First(&role).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			role = Role{Name: br.Name, DisplayName: br.DisplayName, Builtin: true}
+			for _, p := range all {
+				if slices.Contains(br.Permissions, p.Code) {
+					role.Permissions = append(role.Permissions, p)
+				}
+			}
+			if err := db.Create(&role).Error; err != nil {
+				log.Printf("⚠️ 创建内置角色 %s 失败: %v", br.Name, err)
+				continue
+			}
+		}
+		if br.Name == "admin" {
+			db.Model(&role).Association("Permissions").Replace(all)
+		}
+	}
+	invalidatePermissions()
+}
+
+func invalidatePermissions() {
+	permissionCache.Lock()
+	permissionCache.roles = nil
+	permissionCache.Unlock()
+}
+
+// rolePermissions 返回角色拥有的权限点
+func rolePermissions(role string) []string {
+	permissionCache.RLock()
+	perms := permissionCache.roles[role]
+	fresh := permissionCache.roles != nil && time.Since(permissionCache.loadedAt) < permissionCacheTTL
+	permissionCache.RUnlock()
+	if fresh {
+		return perms
+	}
+
+	var roles []Role
+	db.Preload("Permissions").Find(&roles)
+	loaded := make(map[string][]string, len(roles))
+	for _, r := range roles {
+		codes := make([]string, 0, len(r.Permissions))
+		for _, p := range r.Permissions {
+			codes = append(codes, p.Code)
+		}
+		loaded[r.Name] = codes
+	}
+	permissionCache.Lock()
+	permissionCache.roles = loaded
+	permissionCache.loadedAt = time.Now()
+	permissionCache.Unlock()
+	return loaded[role]
+}
+
+func hasPermission(role, perm string) bool {
+	return slices.Contains(rolePermissions(role), perm)
+}
+
+// can 判断当前请求的用户是否拥有某个权限
+func can(c *gin.Context, perm string) bool {
+	return hasPermission(c.MustGet("role").(string), perm)
+}
+
+// RequirePermission 要求当前用户拥有全部指定权限，需放在 AuthMiddleware 之后
+func RequirePermission(perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, perm := range perms {
+			if !can(c, perm) {
+				c.AbortWithStatusJSON(403, gin.H{"error": "权限不足"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+type RoleReq struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	Permissions []string `json:"permissions"`
+}
+
+// findPermissions 把权限编码转换为记录，存在未知编码时报错
+func findPermissions(codes []string) ([]Permission, error) {
+	perms := []Permission{}
+	codes = slices.Compact(slices.Sorted(slices.Values(codes)))
+	if len(codes) == 0 {
+		return perms, nil
+	}
+	db.Where("code IN ?", codes).Find(&perms)
+	if len(perms) != len(codes) {
+		return nil, errors.New("包含未知的权限")
+	}
+	return perms, nil
+}
+
+// checkGrantablePermissions 角色中只能放入操作者自己拥有的权限，失败时已写入响应
+func checkGrantablePermissions(c *gin.Context, perms []Permission) bool {
+	actorPerms := rolePermissions(c.MustGet("role").(string))
+	for _, p := range perms {
+		if !slices.Contains(actorPerms, p.Code) {
+			c.JSON(403, gin.H{"error": "不能授予自己没有的权限：" + p.Name})
+			return false
+		}
+	}
+	return true
+}
+
+// checkRoleEditable 不能修改自己所属的角色，也不能修改权限超出自己的角色，失败时已写入响应
+func checkRoleEditable(c *gin.Context, role Role) bool {
+	actorRole := c.MustGet("role").(string)
+	if role.Name == actorRole {
+		c.JSON(403, gin.H{"error": "不能修改自己所属的角色"})
+		return false
+	}
+	if !coversRole(actorRole, role.Name) {
+		c.JSON(403, gin.H{"error": "不能修改权限超出自己的角色"})
+		return false
+	}
+	return true
+}
+
+// roleAudit 审计日志中记录的角色内容
+func roleAudit(role Role) gin.H {
+	codes := make([]string, 0, len(role.Permissions))
+	for _, p := range role.Permissions {
+		codes = append(codes, p.Code)
+	}
+	return gin.H{"name": role.Name, "display_name": role.DisplayName, "permissions": codes}
+}
+
+func ListPermissionsHandler(c *gin.Context) {
+	var perms []Permission
+	db.Order("id asc").Find(&perms)
+	c.JSON(200, gin.H{"data": perms})
+}
+
+func ListRolesHandler(c *gin.Context) {
+	var roles []Role
+	db.Preload("Permissions").Order("id asc").Find(&roles)
+	type roleView struct {
+		Role
+		UserCount int64 `json:"user_count"`
+	}
+	views := make([]roleView, 0, len(roles))
+	for _, r := range roles {
+		v := roleView{Role: r}
+		db.Model(&User{}).Where("role = ?", r.Name).Count(&v.UserCount)
+		views = append(views, v)
+	}
+	c.JSON(200, gin.H{"data": views})
+}
+
+// CreateRoleHandler 创建自定义角色，例如助教、内容审核员
+func CreateRoleHandler(c *gin.Context) {
+	var req RoleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if !roleNamePattern.MatchString(req.Name) {
+		c.JSON(400, gin.H{"error": "角色名只能包含小写字母、数字和下划线，且以字母开头"})
+		return
+	}
+	perms, err := findPermissions(req.Permissions)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkGrantablePermissions(c, perms) {
+		return
+	}
+	if roleExists(req.Name) {
+		c.JSON(400, gin.H{"error": "角色已存在"})
+		return
+	}
+	role := Role{Name: req.Name, DisplayName: req.DisplayName, Permissions: perms}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Permissions.*").Create(&role).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditRoleCreate, "role", role.ID, nil, roleAudit(role))
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "创建失败"})
+		return
+	}
+	invalidatePermissions()
+	c.JSON(200, gin.H{"message": "创建成功", "data": role})
+}
+
+// UpdateRoleHandler 修改角色名称和权限，角色标识不可修改
+func UpdateRoleHandler(c *gin.Context) {
+	var role Role
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.Preload("Permissions").First(&role, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "角色不存在"})
+		return
+	}
+	if role.Name == "admin" {
+		c.JSON(400, gin.H{"error": "管理员角色始终拥有全部权限，不能修改"})
+		return
+	}
+	if !checkRoleEditable(c, role) {
+		return
+	}
+	var req RoleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	perms, err := findPermissions(req.Permissions)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkGrantablePermissions(c, perms) {
+		return
+	}
+	before := roleAudit(role)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if req.DisplayName != "" {
+			if err := tx.Model(&role).Update("display_name", req.DisplayName).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&role).Association("Permissions").Replace(perms); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditRoleUpdate, "role", role.ID, before, roleAudit(role))
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "保存失败"})
+		return
+	}
+	invalidatePermissions()
+	db.Preload("Permissions").First(&role, role.ID)
+	c.JSON(200, gin.H{"message": "保存成功", "data": role})
+}
+
+// DeleteRoleHandler 删除自定义角色，仍有用户使用时不允许删除
+func DeleteRoleHandler(c *gin.Context) {
+	var role Role
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.Preload("Permissions").First(&role, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "角色不存在"})
+		return
+	}
+	if role.Builtin {
+		c.JSON(400, gin.H{"error": "内置角色不能删除"})
+		return
+	}
+	if !checkRoleEditable(c, role) {
+		return
+	}
+	var count int64
+	db.Model(&User{}).Where("role = ?", role.Name).Count(&count)
+	if count > 0 {
+		c.JSON(400, gin.H{"error": "仍有用户使用该角色，无法删除"})
+		return
+	}
+	// 硬删除以释放角色名的唯一索引，同时清理角色与权限的关联
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Select(clause.Associations).Delete(&role).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditRoleDelete, "role", role.ID, roleAudit(role), nil)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "删除失败"})
+		return
+	}
+	invalidatePermissions()
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
+
+// checkRoleChange 校验当前用户能否把 user 的角色改为 role，失败时已写入响应。
+// 操作者必须拥有原角色和新角色的全部权限，管理员角色只能由管理员授予，避免借改角色提权
+func checkRoleChange(c *gin.Context, user User, role string) bool {
+	if user.ID == c.MustGet("userID").(uint) {
+		c.JSON(400, gin.H{"error": "不能修改自己的角色"})
+		return false
+	}
+	if !roleExists(role) {
+		c.JSON(400, gin.H{"error": "角色不存在"})
+		return false
+	}
+	actorRole := c.MustGet("role").(string)
+	if role == "admin" && actorRole != "admin" {
+		c.JSON(403, gin.H{"error": "只有管理员可以授予管理员角色"})
+		return false
+	}
+	if !coversRole(actorRole, user.Role) || !coversRole(actorRole, role) {
+		c.JSON(403, gin.H{"error": "不能授予或收回自己没有的权限"})
+		return false
+	}
+	return true
+}
+
+// coversRole 判断 actorRole 是否拥有 role 的全部权限
+func coversRole(actorRole, role string) bool {
+	actorPerms := rolePermissions(actorRole)
+	for _, perm := range rolePermissions(role) {
+		if !slices.Contains(actorPerms, perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// AssignUserRoleHandler 修改用户角色，下次请求立即生效
+func AssignUserRoleHandler(c *gin.Context) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	var user User
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.First(&user, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "用户不存在"})
+		return
+	}
+	if !checkRoleChange(c, user, req.Role) {
+		return
+	}
+	before := user.Role
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", req.Role).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditUserRole, "user", user.ID, gin.H{"role": before}, gin.H{"role": req.Role})
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "修改失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "修改成功"})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAssignUserRole(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	// support 只能管理用户，不能批改作业，也不是管理员
+	createTestRole(t, "support", PermUserManage)
+	admin := createTestUser(t, "admin", "admin")
+	support := createTestUser(t, "support", "support")
+	teacher := createTestUser(t, "teacher", "teacher")
+	adminToken := loginAs(t, admin)
+	supportToken := loginAs(t, support)
+
+	tests := []struct {
+		name   string
+		token  string
+		target uint // 为 0 时新建一个学生作为目标
+		role   string
+		want   int
+	}{
+		{"不能修改自己的角色", supportToken, support.ID, "admin", 400},
+		{"不能授予管理员", supportToken, 0, "admin", 403},
+		{"不能授予自己没有的权限", supportToken, 0, "teacher", 403},
+		{"不能收回自己没有的权限", supportToken, teacher.ID, "student", 403},
+		{"角色不存在", supportToken, 0, "nobody", 400},
+		{"可以授予自己拥有的权限", supportToken, 0, "support", 200},
+		{"管理员可以授予任意角色", adminToken, 0, "teacher", 200},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == 0 {
+				target = createTestUser(t, fmt.Sprintf("target%d", i), "student").ID
+			}
+			w := doRequest(r, http.MethodPut, fmt.Sprintf("/api/v1/admin/users/%d/role", target), tt.token,
+				map[string]string{"role": tt.role})
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	var got User
+	db.First(&got, teacher.ID)
+	if got.Role != "teacher" {
+		t.Fatalf("被拒绝的修改生效了：teacher 的角色变成了 %s", got.Role)
+	}
+}
+
+func TestCoversRole(t *testing.T) {
+	setupTestDB(t)
+	createTestRole(t, "support", PermUserManage)
+	createTestRole(t, "grader", PermHomeworkGrade)
+
+	tests := []struct {
+		actor, role string
+		want        bool
+	}{
+		{"admin", "teacher", true},
+		{"admin", "support", true},
+		{"teacher", "student", true},
+		{"teacher", "grader", true},
+		{"support", "teacher", false},
+		{"grader", "teacher", false},
+		{"teacher", "admin", false},
+		{"support", "support", true},
+	}
+	for _, tt := range tests {
+		if got := coversRole(tt.actor, tt.role); got != tt.want {
+			t.Errorf("coversRole(%q, %q) = %v，期望 %v", tt.actor, tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestRoleEditingEscalation(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	// roles 可以管理用户和角色，但没有模拟登录、审计等权限
+	createTestRole(t, "roles", PermUserManage, PermRoleManage)
+	createTestRole(t, "helper", PermUserManage)
+	token := loginAs(t, createTestUser(t, "roles", "roles"))
+	roleID := func(name string) uint {
+		var role Role
+		db.Where("name = ?", name).First(&role)
+		return role.ID
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"不能创建带有自己没有的权限的角色", http.MethodPost, "/api/v1/admin/roles",
+			RoleReq{Name: "spy", Permissions: []string{PermAuditView}}, 403},
+		{"可以创建权限在自己范围内的角色", http.MethodPost, "/api/v1/admin/roles",
+			RoleReq{Name: "assistant", Permissions: []string{PermUserManage}}, 200},
+		{"不能修改自己所属的角色", http.MethodPut, fmt.Sprintf("/api/v1/admin/roles/%d", roleID("roles")),
+			RoleReq{Permissions: []string{PermUserManage, PermRoleManage, PermUserImpersonate}}, 403},
+		{"不能修改权限超出自己的内置角色", http.MethodPut, fmt.Sprintf("/api/v1/admin/roles/%d", roleID("teacher")),
+			RoleReq{Permissions: []string{PermCourseCreate}}, 403},
+		{"不能给角色加上自己没有的权限", http.MethodPut, fmt.Sprintf("/api/v1/admin/roles/%d", roleID("helper")),
+			RoleReq{Permissions: []string{PermUserManage, PermAuditView}}, 403},
+		{"可以在自己的权限范围内修改角色", http.MethodPut, fmt.Sprintf("/api/v1/admin/roles/%d", roleID("helper")),
+			RoleReq{DisplayName: "助理", Permissions: []string{PermUserManage, PermRoleManage}}, 200},
+		{"不能删除权限超出自己的角色", http.MethodDelete, fmt.Sprintf("/api/v1/admin/roles/%d", roleID("admin")), nil, 400},
+		{"可以删除自己范围内的角色", http.MethodDelete, fmt.Sprintf("/api/v1/admin/roles/%d", roleID("helper")), nil, 200},
+		{"非数字 ID", http.MethodDelete, "/api/v1/admin/roles/1%20OR%201=1", nil, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, tt.method, tt.path, token, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	if hasPermission("roles", PermUserImpersonate) || hasPermission("teacher", PermUserManage) {
+		t.Fatal("被拒绝的权限修改生效了")
+	}
+	var actions []string
+	db.Model(&AuditEvent{}).Order("id asc").Pluck("action", &actions)
+	want := []string{AuditRoleCreate, AuditRoleUpdate, AuditRoleDelete}
+	if fmt.Sprint(actions) != fmt.Sprint(want) {
+		t.Fatalf("审计记录 = %v，期望 %v", actions, want)
+	}
+	var update AuditEvent
+	db.Where("action = ?", AuditRoleUpdate).First(&update)
+	if !strings.Contains(update.Before, `"display_name":"helper"`) || !strings.Contains(update.After, PermRoleManage) ||
+		!strings.Contains(update.After, "助理") {
+		t.Fatalf("角色修改的审计内容不完整: %s -> %s", update.Before, update.After)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 作业评分量规
+// ===========================
+
+type RubricCriterion struct {
+	gorm.Model
+	AssignmentID uint          `gorm:"index" json:"assignment_id"`
+	Title        string        `json:"title"`
+	Description  string        `json:"description" gorm:"type:text"`
+	SortOrder    int           `json:"sort_order"`
+	Levels       []RubricLevel `gorm:"foreignKey:CriterionID" json:"levels"`
+}
+
+type RubricLevel struct {
+	gorm.Model
+	CriterionID uint   `gorm:"index" json:"criterion_id"`
+	Label       string `json:"label"`
+	Points      int    `json:"points"`
+	Description string `json:"description" gorm:"type:text"`
+}
+
+// HomeworkRubricScore 记录一次批改中每个评分项所选的等级
+type HomeworkRubricScore struct {
+	gorm.Model
+	HomeworkID  uint `gorm:"index" json:"homework_id"`
+	CriterionID uint `json:"criterion_id"`
+	LevelID     uint `json:"level_id"`
+	Points      int  `json:"points"`
+}
+
+type RubricSelection struct {
+	CriterionID uint `json:"criterion_id"`
+	LevelID     uint `json:"level_id"`
+}
+
+type RubricLevelReq struct {
+	Label       string `json:"label"`
+	Points      int    `json:"points"`
+	Description string `json:"description"`
+}
+
+type RubricCriterionReq struct {
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Levels      []RubricLevelReq `json:"levels"`
+}
+
+// RubricScoreView 学生端看到的单项得分
+type RubricScoreView struct {
+	Criterion string `json:"criterion"`
+	Level     string `json:"level"`
+	Points    int    `json:"points"`
+	MaxPoints int    `json:"max_points"`
+}
+
+func loadRubric(assignmentID uint) []RubricCriterion {
+	var criteria []RubricCriterion
+	db.Preload("Levels", func(db *gorm.DB) *gorm.DB {
+		return db.Order("points asc")
+	}).Where("assignment_id = ?", assignmentID).Order("sort_order asc, id asc").Find(&criteria)
+	return criteria
+}
+
+func maxPoints(criterion RubricCriterion) int {
+	best := 0
+	for _, l := range criterion.Levels {
+		best = max(best, l.Points)
+	}
+	return best
+}
+
+// scoreByRubric 校验每个评分项恰好选择了一个等级，返回总分和逐项明细
+func scoreByRubric(criteria []RubricCriterion, selections []RubricSelection) (int, []HomeworkRubricScore, error) {
+	chosen := make(map[uint]uint, len(selections))
+	for _, s := range selections {
+		chosen[s.CriterionID] = s.LevelID
+	}
+	if len(chosen) != len(criteria) {
+		return 0, nil, fmt.Errorf("需要为全部 %d 个评分项选择等级", len(criteria))
+	}
+	total := 0
+	scores := make([]HomeworkRubricScore, 0, len(criteria))
+	for _, criterion := range criteria {
+		levelID, ok := chosen[criterion.ID]
+		if !ok {
+			return 0, nil, fmt.Errorf("评分项「%s」未选择等级", criterion.Title)
+		}
+		var level *RubricLevel
+		for i := range criterion.Levels {
+			if criterion.Levels[i].ID == levelID {
+				level = &criterion.Levels[i]
+			}
+		}
+		if level == nil {
+			return 0, nil, fmt.Errorf("评分项「%s」的等级无效", criterion.Title)
+		}
+		total += level.Points
+		scores = append(scores, HomeworkRubricScore{CriterionID: criterion.ID, LevelID: level.ID, Points: level.Points})
+	}
+	return total, scores, nil
+}
+
+// rubricBreakdown 返回作业最近一次按量规批改的逐项得分
+func rubricBreakdown(hw Homework) []RubricScoreView {
+	views := []RubricScoreView{}
+	var scores []HomeworkRubricScore
+	db.Where("homework_id = ?", hw.ID).Find(&scores)
+	if len(scores) == 0 {
+		return views
+	}
+	byCriterion := make(map[uint]HomeworkRubricScore, len(scores))
+	for _, s := range scores {
+		byCriterion[s.CriterionID] = s
+	}
+	for _, criterion := range loadRubric(hw.AssignmentID) {
+		s, ok := byCriterion[criterion.ID]
+		if !ok {
+			continue
+		}
+		view := RubricScoreView{Criterion: criterion.Title, Points: s.Points, MaxPoints: maxPoints(criterion)}
+		for _, l := range criterion.Levels {
+			if l.ID == s.LevelID {
+				view.Level = l.Label
+			}
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+func GetRubricHandler(c *gin.Context) {
+	var assignment Assignment
+	aid, ok := paramID(c, "aid")
+	if !ok {
+		return
+	}
+	if err := db.Where("course_id = ?", c.Param("id")).First(&assignment, aid).Error; err != nil {
+		c.JSON(404, gin.H{"error": "作业不存在"})
+		return
+	}
+	c.JSON(200, gin.H{"data": loadRubric(assignment.ID)})
+}
+
+// SaveRubricHandler 整体替换作业的评分量规，已有按量规批改的提交时不允许修改
+func SaveRubricHandler(c *gin.Context) {
+	course, ok := loadManagedCourse(c)
+	if !ok {
+		return
+	}
+	assignment, ok := loadAssignment(c, course)
+	if !ok {
+		return
+	}
+	var req struct {
+		Criteria []RubricCriterionReq `json:"criteria"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+
+	total := 0
+	for _, cr := range req.Criteria {
+		if cr.Title == "" || len(cr.Levels) == 0 {
+			c.JSON(400, gin.H{"error": "每个评分项需要标题和至少一个等级"})
+			return
+		}
+		best := 0
+		for _, l := range cr.Levels {
+			if l.Points < 0 {
+				c.JSON(400, gin.H{"error": "等级分值不能为负"})
+				return
+			}
+			best = max(best, l.Points)
+		}
+		total += best
+	}
+	if total > assignment.MaxScore {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("量规满分 %d 超过作业满分 %d", total, assignment.MaxScore)})
+		return
+	}
+
+	var used int64
+	db.Model(&HomeworkRubricScore{}).
+		Where("criterion_id IN (?)", db.Model(&RubricCriterion{}).Select("id").Where("assignment_id = ?", assignment.ID)).
+		Count(&used)
+	if used > 0 {
+		c.JSON(400, gin.H{"error": "已有作业按此量规批改，无法修改"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var oldIDs []uint
+		tx.Model(&RubricCriterion{}).Where("assignment_id = ?", assignment.ID).Pluck("id", &oldIDs)
+		if len(oldIDs) > 0 {
+			if err := tx.Where("criterion_id IN ?", oldIDs).Delete(&RubricLevel{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", oldIDs).Delete(&RubricCriterion{}).Error; err != nil {
+				return err
+			}
+		}
+		for i, cr := range req.Criteria {
+			criterion := RubricCriterion{AssignmentID: assignment.ID, Title: cr.Title, Description: cr.Description, SortOrder: i}
+			for _, l := range cr.Levels {
+				criterion.Levels = append(criterion.Levels, RubricLevel{Label: l.Label, Points: l.Points, Description: l.Description})
+			}
+			if err := tx.Create(&criterion).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "保存失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "保存成功", "data": loadRubric(assignment.ID)})
+}
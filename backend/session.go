@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 登录会话与刷新令牌
+// ===========================
+
+// Session 一次登录对应一个会话，刷新令牌只保存哈希，每次刷新都会轮换
+type Session struct {
+	gorm.Model
+	UserID          uint       `gorm:"index" json:"user_id"`
+	RefreshHash     string     `gorm:"size:64;uniqueIndex" json:"-"`
+	PrevRefreshHash string     `gorm:"size:64;index" json:"-"` // 上一个刷新令牌，被重复使用说明令牌可能泄露
+	Device          string     `json:"device"`
+	IP              string     `json:"ip"`
+	UserAgent       string     `json:"user_agent"`
+	LastSeenAt      time.Time  `json:"last_seen_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+}
+
+// 会话最后活跃时间的刷新间隔，避免每个请求都写库
+const sessionTouchInterval = time.Minute
+
+func (s Session) active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// parseSessionLimits 解析 "student=5,teacher=3" 形式的会话数配置
+func parseSessionLimits(raw string) map[string]int {
+	limits := map[string]int{}
+	for _, part := range strings.Split(raw, ",") {
+		role, n, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		if v, err := strconv.Atoi(strings.TrimSpace(n)); err == nil {
+			limits[strings.TrimSpace(role)] = v
+		}
+	}
+	return limits
+}
+
+// deviceName 根据 User-Agent 粗略识别设备，用于会话列表展示
+func deviceName(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"):
+		return "iPhone"
+	case strings.Contains(ua, "iPad"):
+		return "iPad"
+	case strings.Contains(ua, "Android"):
+		return "Android"
+	case strings.Contains(ua, "Windows"):
+		return "Windows"
+	case strings.Contains(ua, "Macintosh"):
+		return "Mac"
+	case strings.Contains(ua, "Linux"):
+		return "Linux"
+	}
+	return "未知设备"
+}
+
+func newRefreshToken() (string, string) {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// enforceSessionLimit 超出角色允许的会话数时，吊销最久未活跃的会话
+func enforceSessionLimit(tx *gorm.DB, user User) error {
+	limit, ok := SESSION_LIMITS[user.Role]
+	if !ok || limit <= 0 {
+		return nil
+	}
+	var sessions []Session
+	tx.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("last_seen_at desc").Find(&sessions)
+	if len(sessions) <= limit {
+		return nil
+	}
+	ids := make([]uint, 0, len(sessions)-limit)
+	for _, s := range sessions[limit:] {
+		ids = append(ids, s.ID)
+	}
+	return tx.Model(&Session{}).Where("id IN ?", ids).Update("revoked_at", time.Now()).Error
+}
+
+// issueSession 为通过认证的用户创建会话，返回前端需要的令牌信息
+func issueSession(c *gin.Context, user User, device string) (gin.H, error) {
+	refreshToken, refreshHash := newRefreshToken()
+	ua := c.GetHeader("User-Agent")
+	if device == "" {
+		device = deviceName(ua)
+	}
+	now := time.Now()
+	session := Session{
+		UserID:      user.ID,
+		RefreshHash: refreshHash,
+		Device:      device,
+		IP:          c.ClientIP(),
+		UserAgent:   ua,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(REFRESH_TOKEN_TTL),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		return enforceSessionLimit(tx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	token, err := GenerateToken(user.ID, user.Role, user.TokenVersion, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(ACCESS_TOKEN_TTL.Seconds()),
+		"role":          user.Role,
+		"username":      user.Username,
+		"user_id":       user.ID,
+		"permissions":   rolePermissions(user.Role),
+		// 为 true 时前端应引导用户先修改密码，其余接口在改密前都会返回 403
+		"password_change_required": user.MustChangePassword,
+	}, nil
+}
+
+// RefreshTokenHandler 用刷新令牌换取新的访问令牌，同时轮换刷新令牌
+func RefreshTokenHandler(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	hash := hashToken(req.RefreshToken)
+	now := time.Now()
+
+	var session Session
+	if err := db.Where("refresh_hash = ?", hash).First(&session).Error; err != nil {
+		// 已轮换掉的旧令牌再次出现，说明令牌被盗用，直接吊销整个会话
+		if db.Where("prev_refresh_hash = ?", hash).First(&session).Error == nil {
+			db.Model(&session).Update("revoked_at", now)
+		}
+		c.JSON(401, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+	if !session.active(now) {
+		c.JSON(401, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+	var user User
+	if err := db.First(&user, session.UserID).Error; err != nil || user.disabled() {
+		c.JSON(401, gin.H{"error": "用户状态异常"})
+		return
+	}
+
+	refreshToken, refreshHash := newRefreshToken()
+	// 以旧哈希作为条件更新，并发刷新时只有一个请求能成功
+	res := db.Model(&Session{}).Where("id = ? AND refresh_hash = ?", session.ID, hash).Updates(map[string]interface{}{
+		"refresh_hash":      refreshHash,
+		"prev_refresh_hash": hash,
+		"expires_at":        now.Add(REFRESH_TOKEN_TTL),
+		"last_seen_at":      now,
+		"ip":                c.ClientIP(),
+		"user_agent":        c.GetHeader("User-Agent"),
+	})
+	if res.Error != nil || res.RowsAffected == 0 {
+		c.JSON(401, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+	token, _ := GenerateToken(user.ID, user.Role, user.TokenVersion, session.ID)
+	c.JSON(200, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(ACCESS_TOKEN_TTL.Seconds()),
+	})
+}
+
+// LogoutHandler 吊销当前会话
+func LogoutHandler(c *gin.Context) {
+	sessionID := c.MustGet("sessionID").(uint)
+	db.Model(&Session{}).Where("id = ?", sessionID).Update("revoked_at", time.Now())
+	c.JSON(200, gin.H{"message": "已退出登录"})
+}
+
+// SessionView 会话列表中的一项，current 标记发起请求的会话
+type SessionView struct {
+	Session
+	Current bool `json:"current"`
+}
+
+func listActiveSessions(userID, currentID uint) []SessionView {
+	var sessions []Session
+	db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").Find(&sessions)
+	views := make([]SessionView, 0, len(sessions))
+	for _, s := range sessions {
+		views = append(views, SessionView{Session: s, Current: s.ID == currentID})
+	}
+	return views
+}
+
+// revokeSession 吊销用户的某个会话，返回是否找到了仍有效的会话
+func revokeSession(userID uint, sessionID string) bool {
+	res := db.Model(&Session{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+func ListMySessionsHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	sessionID := c.MustGet("sessionID").(uint)
+	c.JSON(200, gin.H{"data": listActiveSessions(userID, sessionID)})
+}
+
+func RevokeMySessionHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	if !revokeSession(userID, c.Param("id")) {
+		c.JSON(404, gin.H{"error": "会话不存在或已失效"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "已下线该设备"})
+}
+
+// RevokeOtherSessionsHandler 下线除当前设备外的所有会话
+func RevokeOtherSessionsHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	sessionID := c.MustGet("sessionID").(uint)
+	db.Model(&Session{}).Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, sessionID).
+		Update("revoked_at", time.Now())
+	c.JSON(200, gin.H{"message": "已下线其他设备"})
+}
+
+func AdminListUserSessionsHandler(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	c.JSON(200, gin.H{"data": listActiveSessions(uint(userID), c.MustGet("sessionID").(uint))})
+}
+
+func AdminRevokeUserSessionHandler(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if !revokeSession(uint(userID), c.Param("sid")) {
+		c.JSON(404, gin.H{"error": "会话不存在或已失效"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "已下线该设备"})
+}
+
+// AdminRevokeAllUserSessionsHandler 下线用户的全部会话
+func AdminRevokeAllUserSessionsHandler(c *gin.Context) {
+	db.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", c.Param("id")).Update("revoked_at", time.Now())
+	c.JSON(200, gin.H{"message": "已下线全部设备"})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 教师资格申请
+// ===========================
+
+const (
+	ApplicationPending  = "pending"
+	ApplicationApproved = "approved"
+	ApplicationRejected = "rejected"
+)
+
+// TeacherApplication 学生申请成为教师，资质材料存放在私有桶中，管理员审核通过后角色改为 teacher
+type TeacherApplication struct {
+	gorm.Model
+	UserID         uint       `gorm:"index" json:"user_id"`
+	User           User       `gorm:"foreignKey:UserID" json:"user"`
+	Bio            string     `json:"bio" gorm:"type:text"`
+	CredentialName string     `json:"credential_name"`
+	CredentialObj  string     `json:"-"`
+	Status         string     `gorm:"size:16;index;default:pending" json:"status"`
+	ReviewerID     uint       `json:"reviewer_id"`
+	ReviewComment  string     `json:"review_comment"`
+	ReviewedAt     *time.Time `json:"reviewed_at"`
+}
+
+const maxCredentialSize = 20 << 20 // 资质材料上限 20MB
+
+var errAlreadyReviewed = errors.New("该申请已审核")
+
+// ApplyTeacherHandler 提交教师申请，表单字段 bio 和资质文件 file
+func ApplyTeacherHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	if c.MustGet("role").(string) != "student" {
+		c.JSON(400, gin.H{"error": "只有学生账号可以申请成为教师"})
+		return
+	}
+	bio := c.PostForm("bio")
+	if bio == "" {
+		c.JSON(400, gin.H{"error": "请填写个人简介"})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "请上传资质证明材料"})
+		return
+	}
+	if file.Size > maxCredentialSize {
+		c.JSON(400, gin.H{"error": "资质材料不能超过20MB"})
+		return
+	}
+	var pending int64
+	db.Model(&TeacherApplication{}).Where("user_id = ? AND status = ?", userID, ApplicationPending).Count(&pending)
+	if pending > 0 {
+		c.JSON(400, gin.H{"error": "已有待审核的申请，请耐心等待"})
+		return
+	}
+	objectName, err := putUploadedFile(BUCKET_CREDENTIALS, file)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "上传失败"})
+		return
+	}
+	app := TeacherApplication{
+		UserID:         userID,
+		Bio:            bio,
+		CredentialName: file.Filename,
+		CredentialObj:  objectName,
+		Status:         ApplicationPending,
+	}
+	db.Create(&app)
+	c.JSON(200, gin.H{"message": "申请已提交，等待管理员审核", "data": app})
+}
+
+// MyTeacherApplicationHandler 查看自己最近一次申请的状态
+func MyTeacherApplicationHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var app TeacherApplication
+	if err := db.Where("user_id = ?", userID).Order("id desc").First(&app).Error; err != nil {
+		c.JSON(200, gin.H{"data": nil})
+		return
+	}
+	c.JSON(200, gin.H{"data": app})
+}
+
+func ListTeacherApplicationsHandler(c *gin.Context) {
+	status := c.DefaultQuery("status", ApplicationPending)
+	var apps []TeacherApplication
+	query := db.Preload("User").Order("created_at asc")
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	query.Find(&apps)
+	c.JSON(200, gin.H{"data": apps})
+}
+
+// ReviewTeacherApplicationHandler 审核申请，通过后把申请人的角色改为 teacher 并更新简介
+func ReviewTeacherApplicationHandler(c *gin.Context) {
+	reviewerID := c.MustGet("userID").(uint)
+	var req struct {
+		Action  string `json:"action"` // approve / reject
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if req.Action != "approve" && req.Action != "reject" {
+		c.JSON(400, gin.H{"error": "未知的审核操作"})
+		return
+	}
+	if req.Action == "reject" && req.Comment == "" {
+		c.JSON(400, gin.H{"error": "驳回需填写原因"})
+		return
+	}
+	var app TeacherApplication
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.Preload("User").First(&app, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "申请不存在"})
+		return
+	}
+
+	status := ApplicationRejected
+	if req.Action == "approve" {
+		status = ApplicationApproved
+	}
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 以 pending 为条件，防止重复审核
+		res := tx.Model(&TeacherApplication{}).Where("id = ? AND status = ?", app.ID, ApplicationPending).Updates(map[string]interface{}{
+			"status":         status,
+			"reviewer_id":    reviewerID,
+			"review_comment": req.Comment,
+			"reviewed_at":    &now,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errAlreadyReviewed
+		}
+		after := gin.H{"status": status, "comment": req.Comment, "user_id": app.UserID}
+		if status == ApplicationApproved {
+			// 期间被管理员改成其他角色的账号不做改动
+			res := tx.Model(&User{}).Where("id = ? AND role = ?", app.UserID, "student").
+				Updates(map[string]interface{}{"role": "teacher", "bio": app.Bio})
+			if res.Error != nil {
+				return res.Error
+			}
+			after["role_changed"] = res.RowsAffected > 0
+		}
+		return recordAudit(tx, c, AuditTeacherReview, "teacher_application", app.ID, gin.H{"status": app.Status}, after)
+	})
+	if errors.Is(err, errAlreadyReviewed) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "审核失败"})
+		return
+	}
+	notifyApplicationResult(app.User, status, req.Comment)
+	c.JSON(200, gin.H{"message": "审核完成"})
+}
+
+// notifyApplicationResult 申请人邮箱已验证时邮件通知审核结果
+func notifyApplicationResult(user User, status, comment string) {
+	if user.Email == nil || user.EmailVerifiedAt == nil {
+		return
+	}
+	subject, body := "【在线教育平台】教师申请已通过", "你的教师资格申请已通过，重新进入平台即可创建课程。\n"
+	if status == ApplicationRejected {
+		subject, body = "【在线教育平台】教师申请未通过", "你的教师资格申请未通过，原因："+comment+"\n"
+	}
+	go mailer.Send(*user.Email, subject, body)
+}
+
+// TeacherApplicationCredentialHandler 为审核人员签发资质材料的临时下载地址
+func TeacherApplicationCredentialHandler(c *gin.Context) {
+	var app TeacherApplication
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.First(&app, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "申请不存在"})
+		return
+	}
+	params := url.Values{}
+	params.Set("response-content-disposition", "attachment; filename*=UTF-8''"+url.PathEscape(app.CredentialName))
+	u, err := minioPresignClient.PresignedGetObject(context.Background(), BUCKET_CREDENTIALS, app.CredentialObj, attachmentURLTTL, params)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "生成下载地址失败"})
+		return
+	}
+	c.JSON(200, gin.H{"url": u.String(), "expires_in": int(attachmentURLTTL.Seconds())})
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ===========================
+// 管理员用户管理
+// ===========================
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var (
+	errUserExists = errors.New("用户名或邮箱已存在")
+	errEmailTaken = errors.New("邮箱已被使用")
+)
+
+// 需要修改密码的账号在改密前只能访问这些接口
+var passwordChangePaths = map[string]bool{
+	"/api/v1/user/profile": true,
+	"/api/v1/logout":       true,
+}
+
+func (u User) disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// loadTargetUser 加载路由中的用户，不允许管理员对自己执行禁用、删除等操作，失败时已写入响应
+func loadTargetUser(c *gin.Context) (User, bool) {
+	var user User
+	id, ok := paramID(c, "id")
+	if !ok {
+		return user, false
+	}
+	if err := db.First(&user, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "用户不存在"})
+		return user, false
+	}
+	if user.ID == c.MustGet("userID").(uint) {
+		c.JSON(400, gin.H{"error": "不能对自己执行该操作"})
+		return user, false
+	}
+	// 只能管理权限不超过自己的账号，避免借重置密码、禁用等操作接管更高权限的账号
+	if !coversRole(c.MustGet("role").(string), user.Role) {
+		c.JSON(403, gin.H{"error": "不能管理权限高于自己的账号"})
+		return user, false
+	}
+	return user, true
+}
+
+// revokeUserSessions 让用户已签发的令牌和 API Key 全部失效，keepSessionID 不为 0 时保留该会话，
+// 会话的持有者需要用刷新令牌或重新签发的访问令牌继续访问
+func revokeUserSessions(tx *gorm.DB, userID, keepSessionID uint) error {
+	now := time.Now()
+	if err := tx.Model(&User{}).Where("id = ?", userID).Update("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Session{}).Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepSessionID).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", now).Error
+}
+
+func roleExists(name string) bool {
+	var count int64
+	db.Model(&Role{}).Where("name = ?", name).Count(&count)
+	return count > 0
+}
+
+// pageParams 读取 page、page_size 查询参数，非法值回退到默认值
+func pageParams(c *gin.Context) (page, pageSize int) {
+	page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ = strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultPageSize)))
+	page = max(page, 1)
+	if pageSize < 1 || pageSize > maxPageSize {
+		pageSize = defaultPageSize
+	}
+	return page, pageSize
+}
+
+// AdminListUsersHandler 分页查询用户，支持按角色、状态和关键字筛选
+func AdminListUsersHandler(c *gin.Context) {
+	page, pageSize := pageParams(c)
+
+	tx := db.Model(&User{})
+	if role := c.Query("role"); role != "" {
+		tx = tx.Where("role = ?", role)
+	}
+	switch c.Query("status") {
+	case "active":
+		tx = tx.Where("disabled_at IS NULL")
+	case "disabled":
+		tx = tx.Where("disabled_at IS NOT NULL")
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + q + "%"
+		tx = tx.Where("username LIKE ? OR email LIKE ?", like, like)
+	}
+	var total int64
+	tx.Count(&total)
+	var users []User
+	tx.Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users)
+	c.JSON(200, gin.H{"data": users, "total": total, "page": page, "page_size": pageSize})
+}
+
+func AdminGetUserHandler(c *gin.Context) {
+	var user User
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.First(&user, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "用户不存在"})
+		return
+	}
+	var courseCount, enrollCount, sessionCount int64
+	db.Model(&Course{}).Where("teacher_id = ?", user.ID).Count(&courseCount)
+	db.Model(&Enrollment{}).Where("user_id = ?", user.ID).Count(&enrollCount)
+	db.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).Count(&sessionCount)
+	c.JSON(200, gin.H{"data": user, "course_count": courseCount, "enroll_count": enrollCount, "session_count": sessionCount})
+}
+
+// AdminCreateUserHandler 管理员直接创建账号，首次登录需要修改密码
+func AdminCreateUserHandler(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+		Email    string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Username) == "" {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	if len(req.Password) < minPasswordLen {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("密码至少 %d 位", minPasswordLen)})
+		return
+	}
+	if strings.HasPrefix(req.Username, deletedUserPrefix) {
+		c.JSON(400, gin.H{"error": "该用户名为系统保留"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = "student"
+	}
+	if !roleExists(req.Role) {
+		c.JSON(400, gin.H{"error": "角色不存在"})
+		return
+	}
+	if actorRole := c.MustGet("role").(string); (req.Role == "admin" && actorRole != "admin") || !coversRole(actorRole, req.Role) {
+		c.JSON(403, gin.H{"error": "不能创建权限高于自己的账号"})
+		return
+	}
+	hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	user := User{Username: strings.TrimSpace(req.Username), Password: string(hashedPwd), Role: req.Role, MustChangePassword: true}
+	if req.Email != "" {
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		user.Email = &email
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return errUserExists
+		}
+		return recordAudit(tx, c, AuditUserCreate, "user", user.ID, nil,
+			gin.H{"username": user.Username, "role": user.Role, "email": user.Email})
+	})
+	if errors.Is(err, errUserExists) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "创建失败"})
+		return
+	}
+	sendVerificationEmail(user)
+	c.JSON(200, gin.H{"message": "创建成功", "data": user})
+}
+
+// AdminUpdateUserHandler 修改用户的邮箱和简介，角色通过 PUT /admin/users/:id/role 修改
+func AdminUpdateUserHandler(c *gin.Context) {
+	var req struct {
+		Email *string `json:"email"`
+		Bio   *string `json:"bio"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "参数错误"})
+		return
+	}
+	var user User
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	if err := db.First(&user, id).Error; err != nil {
+		c.JSON(404, gin.H{"error": "用户不存在"})
+		return
+	}
+	// 改邮箱后可以通过找回密码接管账号，同样要求权限不低于对方
+	if user.ID != c.MustGet("userID").(uint) && !coversRole(c.MustGet("role").(string), user.Role) {
+		c.JSON(403, gin.H{"error": "不能管理权限高于自己的账号"})
+		return
+	}
+	updates := map[string]interface{}{}
+	if req.Email != nil {
+		if *req.Email == "" {
+			updates["email"], updates["email_verified_at"] = nil, nil
+		} else {
+			email, err := normalizeEmail(*req.Email)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			if user.Email == nil || *user.Email != email {
+				updates["email"], updates["email_verified_at"] = email, nil
+			}
+		}
+	}
+	if req.Bio != nil {
+		updates["bio"] = *req.Bio
+	}
+	if len(updates) > 0 {
+		before := gin.H{}
+		for k := range updates {
+			switch k {
+			case "email":
+				before[k] = user.Email
+			case "bio":
+				before[k] = user.Bio
+			}
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(updates).Error; err != nil {
+				return errEmailTaken
+			}
+			return recordAudit(tx, c, AuditUserUpdate, "user", user.ID, before, updates)
+		})
+		if errors.Is(err, errEmailTaken) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "保存失败"})
+			return
+		}
+	}
+	db.First(&user, user.ID)
+	c.JSON(200, gin.H{"message": "保存成功", "data": user})
+}
+
+// AdminDisableUserHandler 禁用账号并让其所有登录立即失效
+func AdminDisableUserHandler(c *gin.Context) {
+	user, ok := loadTargetUser(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&req)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"disabled_at": time.Now(), "disabled_reason": req.Reason}).Error; err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, user.ID, 0); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditUserDisable, "user", user.ID, nil, gin.H{"reason": req.Reason})
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "操作失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "已禁用该账号"})
+}
+
+func AdminEnableUserHandler(c *gin.Context) {
+	user, ok := loadTargetUser(c)
+	if !ok {
+		return
+	}
+	before := gin.H{"disabled_at": user.DisabledAt, "reason": user.DisabledReason}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"disabled_at": nil, "disabled_reason": ""}).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditUserEnable, "user", user.ID, before, nil)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "操作失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "已启用该账号"})
+}
+
+// AdminResetUserPasswordHandler 重置为临时密码，用户下次登录后必须先修改密码。
+// 不传密码时随机生成并在响应中返回
+func AdminResetUserPasswordHandler(c *gin.Context) {
+	user, ok := loadTargetUser(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Password string `json:"password"`
+	}
+	c.ShouldBindJSON(&req)
+	generated := req.Password == ""
+	if generated {
+		req.Password = randomString()[:12]
+	}
+	if len(req.Password) < minPasswordLen {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("密码至少 %d 位", minPasswordLen)})
+		return
+	}
+	hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"password": string(hashedPwd), "must_change_password": true}).Error; err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, user.ID, 0); err != nil {
+			return err
+		}
+		// 审计中不记录密码本身
+		return recordAudit(tx, c, AuditUserResetPwd, "user", user.ID, nil, gin.H{"generated": generated})
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "重置失败"})
+		return
+	}
+	resp := gin.H{"message": "密码已重置，用户下次登录需修改密码"}
+	if generated {
+		resp["password"] = req.Password
+	}
+	c.JSON(200, resp)
+}
+
+// AdminDeleteUserHandler 软删除账号，已有的课程、作业等记录保留。
+// erase=true 时按用户的注销申请清除个人信息，与用户自行注销效果相同
+func AdminDeleteUserHandler(c *gin.Context) {
+	user, ok := loadTargetUser(c)
+	if !ok {
+		return
+	}
+	before := gin.H{"username": user.Username, "role": user.Role, "email": user.Email}
+	if c.Query("erase") == "true" {
+		err := eraseUser(user, func(tx *gorm.DB) error {
+			return recordAudit(tx, c, AuditUserErase, "user", user.ID, before, nil)
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": "删除失败"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "已清除该用户的个人信息"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := revokeUserSessions(tx, user.ID, 0); err != nil {
+			return err
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, AuditUserDelete, "user", user.ID, before, nil)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "删除成功"})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestMustChangePassword(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	user := createTestUser(t, "temp", "student")
+	db.Model(&user).Update("must_change_password", true)
+	token := loginAs(t, user)
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"普通接口被拦截", "/api/v1/my-courses", 403},
+		{"允许查看个人资料", "/api/v1/user/profile", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, http.MethodGet, tt.path, token, nil)
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestManageHigherPrivilegedAccounts(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	// support 只能管理用户，权限低于 teacher 和 admin
+	createTestRole(t, "support", PermUserManage)
+	admin := createTestUser(t, "admin", "admin")
+	teacher := createTestUser(t, "teacher", "teacher")
+	student := createTestUser(t, "student", "student")
+	token := loginAs(t, createTestUser(t, "support", "support"))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"不能禁用管理员", http.MethodPost, fmt.Sprintf("/api/v1/admin/users/%d/disable", admin.ID),
+			map[string]string{"reason": "test"}, 403},
+		{"不能重置教师密码", http.MethodPost, fmt.Sprintf("/api/v1/admin/users/%d/reset-password", teacher.ID), nil, 403},
+		{"不能修改教师资料", http.MethodPut, fmt.Sprintf("/api/v1/admin/users/%d", teacher.ID),
+			map[string]string{"username": "renamed"}, 403},
+		{"可以禁用学生", http.MethodPost, fmt.Sprintf("/api/v1/admin/users/%d/disable", student.ID),
+			map[string]string{"reason": "test"}, 200},
+		{"不能创建管理员", http.MethodPost, "/api/v1/admin/users",
+			map[string]string{"username": "new_admin", "password": testPassword, "role": "admin"}, 403},
+		{"不能创建教师", http.MethodPost, "/api/v1/admin/users",
+			map[string]string{"username": "new_teacher", "password": testPassword, "role": "teacher"}, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, tt.method, tt.path, token, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("状态码 = %d，期望 %d，响应 %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	var got User
+	db.First(&got, teacher.ID)
+	if got.Username != "teacher" {
+		t.Fatalf("被拒绝的修改生效了：用户名变成了 %s", got.Username)
+	}
+}
+
+func TestUserManagementAudit(t *testing.T) {
+	setupTestDB(t)
+	r := setupRouter()
+	token := loginAs(t, createTestUser(t, "admin", "admin"))
+	target := createTestUser(t, "target", "student")
+	erased := createTestUser(t, "erased", "student")
+	applicant := createTestUser(t, "applicant", "student")
+	app := TeacherApplication{UserID: applicant.ID, Bio: "十年教龄", Status: ApplicationPending}
+	db.Create(&app)
+
+	tests := []struct {
+		method, path string
+		body         interface{}
+		action       string
+		targetID     uint
+	}{
+		{http.MethodPost, "/api/v1/admin/users", map[string]string{"username": "created", "password": testPassword},
+			AuditUserCreate, 0},
+		{http.MethodPut, fmt.Sprintf("/api/v1/admin/users/%d", target.ID), map[string]string{"bio": "新简介"},
+			AuditUserUpdate, target.ID},
+		{http.MethodPost, fmt.Sprintf("/api/v1/admin/users/%d/unlock", target.ID), nil, AuditUserUnlock, target.ID},
+		{http.MethodPut, fmt.Sprintf("/api/v1/admin/teacher-applications/%d", app.ID), map[string]string{"action": "approve"},
+			AuditTeacherReview, app.ID},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/admin/users/%d", target.ID), nil, AuditUserDelete, target.ID},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/admin/users/%d?erase=true", erased.ID), nil, AuditUserErase, erased.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			w := doRequest(r, tt.method, tt.path, token, tt.body)
+			if w.Code != 200 {
+				t.Fatalf("状态码 = %d，响应 %s", w.Code, w.Body.String())
+			}
+			var event AuditEvent
+			if err := db.Where("action = ?", tt.action).First(&event).Error; err != nil {
+				t.Fatalf("没有写入审计日志 %s", tt.action)
+			}
+			if tt.targetID != 0 && event.TargetID != tt.targetID {
+				t.Fatalf("审计目标 = %d，期望 %d", event.TargetID, tt.targetID)
+			}
+		})
+	}
+
+	var update AuditEvent
+	db.Where("action = ?", AuditUserUpdate).First(&update)
+	if update.Before != `{"bio":""}` || update.After != `{"bio":"新简介"}` {
+		t.Fatalf("修改资料的审计内容不正确: %s -> %s", update.Before, update.After)
+	}
+}
@@ -1,9 +1,9 @@
 import axios from 'axios'
 import { ElMessage } from 'element-plus'
 
-// 指向你的 Go 本地后端
+// 后端不再直接对外暴露，统一经 nginx 的 /api/ 转发；本地开发由 vite 代理到 nginx
 const request = axios.create({
-    baseURL: 'http://localhost:8080/api/v1', 
+    baseURL: '/api/v1', 
     timeout: 5000
 })
 
@@ -18,15 +18,49 @@ request.interceptors.request.use(config => {
     return Promise.reject(error)
 })
 
+// 用刷新令牌换新的访问令牌，多个请求同时 401 时只刷新一次
+let refreshing = null
+const refreshToken = () => {
+    if (!refreshing) {
+        const token = localStorage.getItem('refresh_token')
+        refreshing = (token ? axios.post(`${request.defaults.baseURL}/token/refresh`, { refresh_token: token })
+            : Promise.reject(new Error('no refresh token')))
+            .then(res => {
+                localStorage.setItem('token', res.data.token)
+                localStorage.setItem('refresh_token', res.data.refresh_token)
+                return res.data.token
+            })
+            .finally(() => { refreshing = null })
+    }
+    return refreshing
+}
+
 // 响应拦截器：处理错误
 request.interceptors.response.use(response => {
     return response.data
-}, error => {
-    // 如果是 401 说明 Token 过期或未登录
+}, async error => {
+    const config = error.config
+    // 访问令牌过期时先尝试续期并重放原请求
+    if (error.response && error.response.status === 401 && config && !config._retried && !config.url.startsWith('/login')) {
+        config._retried = true
+        try {
+            const token = await refreshToken()
+            config.headers['Authorization'] = `Bearer ${token}`
+            return request(config)
+        } catch (e) {
+            // 续期失败，落到下面的重新登录逻辑
+        }
+    }
+    // 如果是 401 说明登录已失效
     if (error.response && error.response.status === 401) {
         ElMessage.error('登录已过期，请重新登录')
         localStorage.removeItem('token')
+        localStorage.removeItem('refresh_token')
         window.location.href = '/login'
+    } else if (error.response?.data?.password_change_required) {
+        // 管理员重置过密码，修改密码前其他功能不可用
+        ElMessage.warning('请先修改密码')
+        if (window.location.pathname !== '/profile') window.location.href = '/profile'
     } else {
         ElMessage.error(error.response?.data?.error || '网络错误')
     }